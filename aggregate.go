@@ -0,0 +1,432 @@
+package haddoque
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// findGroup returns the GROUP BY clause of the query, or nil.
+func findGroup(root *seqNode) *groupNode {
+	for _, v := range root.nodes {
+		if g, ok := v.(*groupNode); ok {
+			return g
+		}
+	}
+
+	return nil
+}
+
+// hasAggregates reports whether the query selects aggregates.
+func hasAggregates(root *seqNode) bool {
+	for _, v := range projections(root) {
+		if unalias(v).typ() == nodeAggregate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Aggregator executes a query with aggregates, like
+//
+//	count(), avg(.latency) group by .device.platform where .status == 500
+//
+// on objects added one by one. Only the state of the aggregates of each group is kept,
+// so the memory used is bounded by the number of groups, not by the number of objects.
+//
+// An Aggregator is not safe for concurrent use.
+type Aggregator struct {
+	q         *Query
+	aggs      []*aggregateNode // the selected aggregates, then the ones only used by ORDER BY
+	selected  int
+	aggLocs   [][]interface{} // location of the selected aggregates in the results
+	keyLocs   [][]interface{} // location of the group keys in the results
+	orderKeys []aggOrderKey
+	groups    map[string]*aggGroup
+	order     []*aggGroup // in order of appearance
+
+	onError func(err *RecordError) error
+}
+
+// aggOrderKey is the index of an ORDER BY key in the group keys or in the aggregates, the other index being -1.
+type aggOrderKey struct {
+	group int
+	agg   int
+}
+
+// aggGroup is the state of the aggregates of a group.
+type aggGroup struct {
+	keys []interface{}
+	accs []accumulator
+}
+
+// accumulator is the state of an aggregate.
+type accumulator struct {
+	count int64
+	sum   interface{} // int64 or float64
+	value interface{} // the min or max value
+}
+
+// NewAggregator returns an Aggregator executing the query, which must have aggregates or a GROUP BY clause.
+//
+// The options are the ones of Filter: WithRecordErrorHandler reports the invalid records read by AddStream.
+//
+// ErrNotAggregateQuery is returned for any other query.
+func NewAggregator(q *Query, opts ...FilterOption) (*Aggregator, error) {
+	if !q.aggregate {
+		return nil, ErrNotAggregateQuery
+	}
+
+	var o filterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	a := &Aggregator{q: q, onError: o.onError}
+	for _, v := range projections(q.root) {
+		agg, ok := unalias(v).(*aggregateNode)
+		if !ok {
+			continue
+		}
+
+		a.aggs = append(a.aggs, agg)
+		if alias, ok := v.(*aliasNode); ok {
+			a.aggLocs = append(a.aggLocs, alias.location())
+		} else {
+			a.aggLocs = append(a.aggLocs, []interface{}{agg.text})
+		}
+	}
+	a.selected = len(a.aggs)
+
+	if q.group != nil {
+		for _, k := range q.group.keys {
+			a.keyLocs = append(a.keyLocs, keyLocation(q, k))
+		}
+	}
+
+	if q.order != nil {
+		for _, k := range q.order.keys {
+			a.orderKeys = append(a.orderKeys, a.orderKey(k))
+		}
+	}
+	a.Reset()
+
+	return a, nil
+}
+
+// keyLocation returns the location of a group key in the results: the location given by its alias if it's selected
+// with one, else the location of the field like a selected field, or the text of the call at the top level.
+func keyLocation(q *Query, k node) []interface{} {
+	for _, v := range projections(q.root) {
+		if alias, ok := v.(*aliasNode); ok && sourceText(alias) == sourceText(k) {
+			return alias.location()
+		}
+	}
+
+	if chain, ok := k.(*chainNode); ok {
+		if loc, ok := chain.location(); ok {
+			return loc
+		}
+	}
+
+	return []interface{}{sourceText(k)}
+}
+
+// orderKey finds the ORDER BY key k in the group keys or the aggregates, adding it to the aggregates if needed.
+func (a *Aggregator) orderKey(k node) aggOrderKey {
+	if k.typ() != nodeAggregate {
+		// the parser checked that it's a group key
+		for i, gk := range a.q.group.keys {
+			if sourceText(gk) == sourceText(k) {
+				return aggOrderKey{group: i, agg: -1}
+			}
+		}
+	}
+
+	for i, agg := range a.aggs {
+		if agg.text == sourceText(k) {
+			return aggOrderKey{group: -1, agg: i}
+		}
+	}
+
+	a.aggs = append(a.aggs, k.(*aggregateNode))
+	return aggOrderKey{group: -1, agg: len(a.aggs) - 1}
+}
+
+// Reset discards all the groups.
+func (a *Aggregator) Reset() {
+	a.groups = make(map[string]*aggGroup)
+	a.order = nil
+}
+
+// Add adds an object to the aggregates of its group, if it satisfies the conditions of the query.
+//
+// The object can be anything accepted by Query.Exec, ErrInvalidObject is returned for anything else.
+func (a *Aggregator) Add(obj interface{}) error {
+	_, _, err := a.add(obj)
+	return err
+}
+
+// AddBytes adds a JSON document like Add. Only the parts of the document used by the query are decoded,
+// see Query.ExecBytes.
+//
+// An *InvalidJSONError is returned if the data is not valid JSON.
+func (a *Aggregator) AddBytes(data []byte) error {
+	_, _, err := a.addBytes(data)
+	return err
+}
+
+// AddStream adds each JSON value read from r like AddBytes. The stream is read like Filter does,
+// the invalid records being returned as RecordErrors once the input is exhausted unless reported
+// with WithRecordErrorHandler.
+func (a *Aggregator) AddStream(r io.Reader) error {
+	return streamReader{exec: a.addBytes, onError: a.onError}.read(r, a.q)
+}
+
+func (a *Aggregator) addBytes(data []byte) (interface{}, bool, error) {
+	obj, err := extractJSON(data, a.q.paths)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return a.add(obj)
+}
+
+// add adds an object to its group, reporting whether it satisfied the conditions.
+func (a *Aggregator) add(obj interface{}) (interface{}, bool, error) {
+	on := newObjNode(obj)
+	if on == nil {
+		return nil, false, ErrInvalidObject
+	}
+
+	if !evaluateWhere(a.q.root, on) {
+		return nil, false, nil
+	}
+
+	g := a.group(on)
+	for i, agg := range a.aggs {
+		g.accs[i].add(agg, on)
+	}
+
+	return nil, true, nil
+}
+
+// group returns the group of the object, creating it if needed.
+func (a *Aggregator) group(on *objNode) *aggGroup {
+	var keys []interface{}
+	if a.q.group != nil {
+		keys = make([]interface{}, len(a.q.group.keys))
+		for i, k := range a.q.group.keys {
+			// an absent key is grouped with null
+			keys[i], _ = getValue(k, on)
+		}
+	}
+
+	key := groupKey(keys)
+
+	g, ok := a.groups[key]
+	if !ok {
+		g = &aggGroup{
+			keys: keys,
+			accs: make([]accumulator, len(a.aggs)),
+		}
+		a.groups[key] = g
+		a.order = append(a.order, g)
+	}
+
+	return g
+}
+
+// groupKey returns a representation of the values such that equal values have the same representation.
+func groupKey(keys []interface{}) string {
+	var buf strings.Builder
+	for _, k := range keys {
+		writeGroupKey(&buf, k)
+		buf.WriteByte(0)
+	}
+
+	return buf.String()
+}
+
+// writeGroupKey writes a representation of the value such that equal values, as defined by evaluateEq,
+// have the same representation.
+func writeGroupKey(buf *strings.Builder, v interface{}) {
+	if n, ok := normalizeNumber(v); ok {
+		// integral floats are written like integers, to be equal to them
+		if f, ok := n.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			if bf := new(big.Float).SetFloat64(f); bf.IsInt() {
+				n, _ = bf.Int(nil)
+			}
+		}
+
+		switch t := n.(type) {
+		case float64:
+			buf.WriteString("f" + strconv.FormatFloat(t, 'g', -1, 64))
+		case *big.Int:
+			buf.WriteString("i" + t.String())
+		default:
+			buf.WriteString("i" + toBigInt(t).String())
+		}
+		return
+	}
+
+	switch t := v.(type) {
+	case string:
+		buf.WriteString(strconv.Quote(t))
+	case []interface{}:
+		buf.WriteByte('[')
+		for _, el := range t {
+			writeGroupKey(buf, el)
+			buf.WriteByte(',')
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for _, k := range keys {
+			buf.WriteString(strconv.Quote(k) + ":")
+			writeGroupKey(buf, t[k])
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('}')
+	default:
+		data, _ := json.Marshal(t)
+		buf.Write(data)
+	}
+}
+
+// Results returns the result of each group, ordered and limited as specified by the query.
+// Without ORDER BY clause, the groups are in the order in which they appeared.
+//
+// A result is an object with the group keys at the same location they have in the objects,
+// like selected fields, and the aggregates at the top level with their text as the key,
+// unless they're selected with an alias.
+// Without GROUP BY clause, there is a single group even if no object was added.
+func (a *Aggregator) Results() []interface{} {
+	groups := a.order
+	if a.q.group == nil && len(groups) == 0 {
+		groups = []*aggGroup{{accs: make([]accumulator, len(a.aggs))}}
+	}
+
+	items := make([]*ordered, len(groups))
+	for i, g := range groups {
+		item := &ordered{
+			seq:    i,
+			keys:   make([]interface{}, len(a.orderKeys)),
+			result: a.result(g),
+		}
+
+		for j, k := range a.orderKeys {
+			if k.group >= 0 {
+				item.keys[j] = g.keys[k.group]
+			} else {
+				item.keys[j] = g.accs[k.agg].result(a.aggs[k.agg])
+			}
+		}
+
+		items[i] = item
+	}
+
+	return a.q.sortOrdered(items)
+}
+
+func (a *Aggregator) result(g *aggGroup) interface{} {
+	var res interface{}
+
+	for i, loc := range a.keyLocs {
+		res = project(res, loc, g.keys[i])
+	}
+
+	for i, agg := range a.aggs[:a.selected] {
+		res = project(res, a.aggLocs[i], g.accs[i].result(agg))
+	}
+
+	return finishProjection(res)
+}
+
+// add adds the value of the argument of the aggregate for an object.
+//
+// Absent and null values are ignored, as well as values which aren't numbers for sum and avg,
+// and values which can't be compared to the current one for min and max.
+func (acc *accumulator) add(agg *aggregateNode, on *objNode) {
+	if agg.arg == nil {
+		acc.count++
+		return
+	}
+
+	if agg.cond {
+		if evaluateCondition(agg.arg, on) {
+			acc.count++
+		}
+		return
+	}
+
+	val, ok := getValue(agg.arg, on)
+	if !ok || val == nil {
+		return
+	}
+
+	switch agg.name {
+	case "count":
+		acc.count++
+	case "sum", "avg":
+		n, ok := arithNumber(val)
+		if !ok {
+			return
+		}
+
+		acc.count++
+		if acc.sum == nil {
+			acc.sum = n
+			return
+		}
+
+		li, lIsInt := acc.sum.(int64)
+		ri, rIsInt := n.(int64)
+		if lIsInt && rIsInt {
+			acc.sum, _ = arithInt(tokPlus, li, ri)
+		} else {
+			acc.sum, _ = arithFloat(tokPlus, toFloat(acc.sum), toFloat(n))
+		}
+	case "min", "max":
+		if acc.value == nil {
+			if _, ok := compareValues(val, val); ok {
+				acc.value = val
+			}
+			return
+		}
+
+		c, ok := compareValues(val, acc.value)
+		if ok && ((agg.name == "min" && c < 0) || (agg.name == "max" && c > 0)) {
+			acc.value = val
+		}
+	}
+}
+
+// result returns the value of the aggregate, null if no value was added except for count.
+func (acc *accumulator) result(agg *aggregateNode) interface{} {
+	switch agg.name {
+	case "count":
+		return acc.count
+	case "sum":
+		return acc.sum
+	case "avg":
+		if acc.count == 0 {
+			return nil
+		}
+
+		return toFloat(acc.sum) / float64(acc.count)
+	default:
+		return acc.value
+	}
+}
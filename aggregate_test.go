@@ -0,0 +1,201 @@
+package haddoque_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vrischmann/haddoque"
+)
+
+var aggregateTests = []resultsTest{
+	{
+		`count(), avg(.latency) group by .device.platform where (.status == 500)`,
+		`[{"avg(.latency)":150,"count()":2,"device":{"platform":"android"}},` +
+			`{"avg(.latency)":300,"count()":1,"device":{"platform":"ios"}},` +
+			`{"avg(.latency)":null,"count()":1,"device":{"platform":"web"}},` +
+			`{"avg(.latency)":50.5,"count()":1,"device":{"platform":null}}]`,
+	},
+	{
+		`count(), count(.latency), sum(.latency), min(.latency), max(.latency)`,
+		`[{"count()":6,"count(.latency)":5,"max(.latency)":300,"min(.latency)":10,"sum(.latency)":660.5}]`,
+	},
+	{
+		`sum(.latency), min(.device.platform), max(.device.platform) where .status == 500 and .latency < 300`,
+		`[{"max(.device.platform)":"android","min(.device.platform)":"android","sum(.latency)":350.5}]`,
+	},
+	{
+		`count() where .status == 404`,
+		`[{"count()":0}]`,
+	},
+	{
+		`count(.status == 500), count(.device.platform == "android"), count(.status == 500 and exists(.latency)), count(not exists(.device))`,
+		`[{"count(.device.platform == \"android\")":3,"count(.status == 500 and exists(.latency))":4,"count(.status == 500)":5,"count(not exists(.device))":1}]`,
+	},
+	{
+		`.device.platform, count(.status == 200) group by .device.platform order by count(.status == 200) desc, .device.platform`,
+		`[{"count(.status == 200)":1,"device":{"platform":"android"}},{"count(.status == 200)":0,"device":{"platform":null}},` +
+			`{"count(.status == 200)":0,"device":{"platform":"ios"}},{"count(.status == 200)":0,"device":{"platform":"web"}}]`,
+	},
+	{
+		`count() group by .status where .status == 404`,
+		`[]`,
+	},
+	{
+		`.status, sum(.latency * 2) group by .status`,
+		`[{"status":500,"sum(.latency * 2)":1301},{"status":200,"sum(.latency * 2)":20}]`,
+	},
+	{
+		`.device.platform group by .device.platform, upper(.device.platform) where exists(.device)`,
+		`[{"device":{"platform":"android"},"upper(.device.platform)":"ANDROID"},` +
+			`{"device":{"platform":"ios"},"upper(.device.platform)":"IOS"},` +
+			`{"device":{"platform":"web"},"upper(.device.platform)":"WEB"}]`,
+	},
+	{
+		`.device.platform as platform, count() as stats.n, avg(.latency) as stats.latency group by .device.platform where .status == 500 and exists(.latency)`,
+		`[{"platform":"android","stats":{"latency":150,"n":2}},{"platform":"ios","stats":{"latency":300,"n":1}},` +
+			`{"platform":null,"stats":{"latency":50.5,"n":1}}]`,
+	},
+	{
+		`select sum(.latency * 2) as total order by total`,
+		`[{"total":1321}]`,
+	},
+}
+
+func TestAggregator(t *testing.T) {
+	input := readInput(t, "aggregate.ndjson")
+	for _, test := range aggregateTests {
+		equals(t, test.expected, queryResults(t, test.query, input))
+	}
+}
+
+func TestAggregatorNumbers(t *testing.T) {
+	// equal numbers of different kinds are in the same group, sums overflowing an int64 fall back to float64
+	input := `{"id": 1, "n": 9223372036854775807}
+{"id": 1.0, "n": 1}
+{"id": 10e-1, "n": "foo"}
+{"id": 2, "n": null}
+`
+	equals(t, `[{"count()":3,"id":1,"sum(.n)":9223372036854776000},{"count()":1,"id":2,"sum(.n)":null}]`,
+		queryResults(t, `count(), sum(.n) group by .id`, input))
+
+	agg, err := haddoque.NewAggregator(haddoque.MustCompile(`count(), sum(.n) group by .kind`))
+	ok(t, err)
+	ok(t, agg.Add(map[string]interface{}{"kind": int8(1), "n": uint16(2)}))
+	ok(t, agg.Add(struct {
+		Kind float32 `json:"kind"`
+		N    int     `json:"n"`
+	}{1, 3}))
+	equals(t, []interface{}{map[string]interface{}{"count()": int64(2), "kind": int8(1), "sum(.n)": int64(5)}}, agg.Results())
+
+	agg.Reset()
+	equals(t, []interface{}{}, agg.Results())
+}
+
+func TestAggregatorBytes(t *testing.T) {
+	agg, err := haddoque.NewAggregator(haddoque.MustCompile(`count(), max(.latency) group by .device.platform where .status == 500`))
+	ok(t, err)
+
+	for _, line := range strings.Split(strings.TrimSpace(readInput(t, "aggregate.ndjson")), "\n") {
+		ok(t, agg.AddBytes([]byte(line)))
+	}
+
+	err = agg.AddBytes([]byte(`{"status": 500, "device": `))
+	assert(t, errors.Is(err, haddoque.ErrInvalidObject), "expected an invalid JSON error, got %v", err)
+
+	equals(t, 4, len(agg.Results()))
+	equals(t, map[string]interface{}{
+		"count()":       int64(2),
+		"max(.latency)": json.Number("200"),
+		"device":        map[string]interface{}{"platform": "android"},
+	}, agg.Results()[0])
+}
+
+func TestAggregatorStream(t *testing.T) {
+	agg, err := haddoque.NewAggregator(haddoque.MustCompile(`count() group by .device.platform`))
+	ok(t, err)
+
+	input := "[\n" + strings.Replace(strings.TrimSpace(readInput(t, "aggregate.ndjson")), "\n", ",\n", -1) + "\n]\n" + `{"status": ]` + "\n"
+	err = agg.AddStream(strings.NewReader(input))
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+	equals(t, 1, len(errs.Errors))
+	equals(t, 9, errs.Errors[0].Line)
+
+	equals(t, `[{"count()":3,"device":{"platform":"android"}},{"count()":1,"device":{"platform":"ios"}},`+
+		`{"count()":1,"device":{"platform":"web"}},{"count()":1,"device":{"platform":null}}]`, encodeJSON(t, agg.Results()))
+}
+
+func TestAggregatorOptions(t *testing.T) {
+	var lines []int
+	agg, err := haddoque.NewAggregator(haddoque.MustCompile(`count(), sum(.n)`), haddoque.WithRecordErrorHandler(func(err *haddoque.RecordError) error {
+		lines = append(lines, err.Line)
+		return nil
+	}))
+	ok(t, err)
+
+	ok(t, agg.AddStream(strings.NewReader("{\"n\": 2}\n{\"n\": ]\n{\"n\": 1}\n{\"n\": tru}\n")))
+	equals(t, []int{2, 4}, lines)
+	equals(t, `[{"count()":2,"sum(.n)":3}]`, encodeJSON(t, agg.Results()))
+
+	// the reading stops at the error returned by the handler
+	errStop := errors.New("stop")
+	agg, err = haddoque.NewAggregator(haddoque.MustCompile(`count()`), haddoque.WithRecordErrorHandler(func(err *haddoque.RecordError) error {
+		return errStop
+	}))
+	ok(t, err)
+
+	equals(t, errStop, agg.AddStream(strings.NewReader("{\"n\": 2}\n{\"n\": ]\n{\"n\": 1}\n")))
+	equals(t, `[{"count()":1}]`, encodeJSON(t, agg.Results()))
+}
+
+func TestAggregateQuery(t *testing.T) {
+	q := haddoque.MustCompile(`count() group by .type`)
+	equals(t, true, q.IsAggregate())
+	equals(t, false, haddoque.MustCompile(`.type`).IsAggregate())
+
+	_, err := q.Exec(map[string]interface{}{"type": "click"})
+	equals(t, haddoque.ErrAggregateQuery, err)
+
+	_, err = q.ExecBytes([]byte(`{"type": "click"}`))
+	equals(t, haddoque.ErrAggregateQuery, err)
+
+	equals(t, false, q.Match(map[string]interface{}{"type": "click"}))
+
+	var buf bytes.Buffer
+	equals(t, haddoque.ErrAggregateQuery, haddoque.Filter(strings.NewReader(`{"type": "click"}`), &buf, q))
+
+	_, err = haddoque.NewAggregator(haddoque.MustCompile(`.type where .id > 1`))
+	equals(t, haddoque.ErrNotAggregateQuery, err)
+
+	// a function of the query takes precedence over an aggregate
+	q = haddoque.MustCompile(`count(.tags)`, haddoque.WithFuncs(haddoque.FuncMap{
+		"count": func(v []interface{}) int { return len(v) },
+	}))
+	equals(t, false, q.IsAggregate())
+}
+
+var aggregateCompileErrorTests = []compileErrorTest{
+	{`.name, count()`, `.name must be an aggregate or a group by key`},
+	{`count(), lower(.name) group by .name`, `lower(.name) must be an aggregate or a group by key`},
+	{`.name group by .type`, `.name must be an aggregate or a group by key`},
+	{`.name as type, count() group by .type`, `.name must be an aggregate or a group by key`},
+	{`.latency * 2 as n, count()`, `.latency * 2 must be an aggregate or a group by key`},
+	{`count() where count() > 1`, `aggregate count can only be used as a selected field`},
+	{`lower(sum(.a))`, `aggregate sum can only be used as a selected field`},
+	{`count() group by count()`, `unexpected "count"`},
+	{`count() group .type`, `unexpected ".type"`},
+	{`count() group by`, `unexpected end of query`},
+	{`count() group by .a group by .b`, `duplicate group clause`},
+	{`sum()`, `unexpected ")"`},
+	{`count(.a, .b)`, `unexpected ","`},
+	{`sum(.a == 1)`, `argument of sum must be a value`},
+	{`max((.a > 1))`, `argument of max must be a value`},
+}
+
+func TestAggregateCompileErrors(t *testing.T) {
+	testCompileErrors(t, aggregateCompileErrorTests)
+}
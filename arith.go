@@ -0,0 +1,160 @@
+package haddoque
+
+import (
+	"math"
+)
+
+// evaluateArith evaluates an arithmetic expression, and whether its result is present.
+//
+// Numbers of any kind are first converted to int64 or float64, see arithNumber.
+// Integers stay integers as long as the result fits in an int64, otherwise the operation is done
+// on float64. Like in Go, integer division truncates toward zero.
+// Mixing an integer and a float converts the integer to float64.
+//
+// The result is absent if an operand is absent, null or not a number, or for a division by zero.
+// The only exception is +, which also concatenates two strings.
+func evaluateArith(n *arithNode, on *objNode) (interface{}, bool) {
+	lval, ok := getValue(n.left, on)
+	if !ok {
+		return nil, false
+	}
+
+	rval, ok := getValue(n.right, on)
+	if !ok {
+		return nil, false
+	}
+
+	if ls, ok := lval.(string); ok {
+		rs, ok := rval.(string)
+		if !ok || n.operator != tokPlus {
+			return nil, false
+		}
+
+		return ls + rs, true
+	}
+
+	lval, ok = arithNumber(lval)
+	if !ok {
+		return nil, false
+	}
+
+	rval, ok = arithNumber(rval)
+	if !ok {
+		return nil, false
+	}
+
+	li, lIsInt := lval.(int64)
+	ri, rIsInt := rval.(int64)
+	if lIsInt && rIsInt {
+		return arithInt(n.operator, li, ri)
+	}
+
+	return arithFloat(n.operator, toFloat(lval), toFloat(rval))
+}
+
+// arithInt applies the operator to two integers, falling back to float64 on overflow.
+func arithInt(op token, l, r int64) (interface{}, bool) {
+	switch op {
+	case tokPlus:
+		res := l + r
+		if (l > 0 && r > 0 && res < 0) || (l < 0 && r < 0 && res >= 0) {
+			return float64(l) + float64(r), true
+		}
+
+		return res, true
+	case tokMinus:
+		res := l - r
+		if (l >= 0 && r < 0 && res < 0) || (l < 0 && r > 0 && res >= 0) {
+			return float64(l) - float64(r), true
+		}
+
+		return res, true
+	case tokStar:
+		if l == 0 || r == 0 {
+			return int64(0), true
+		}
+
+		res := l * r
+		if res/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return float64(l) * float64(r), true
+		}
+
+		return res, true
+	case tokSlash:
+		switch {
+		case r == 0:
+			return nil, false
+		case l == math.MinInt64 && r == -1:
+			return -float64(l), true
+		}
+
+		return l / r, true
+	case tokPercent:
+		if r == 0 {
+			return nil, false
+		}
+
+		return l % r, true
+	}
+
+	return nil, false
+}
+
+// arithFloat applies the operator to two floats.
+func arithFloat(op token, l, r float64) (interface{}, bool) {
+	switch op {
+	case tokPlus:
+		return l + r, true
+	case tokMinus:
+		return l - r, true
+	case tokStar:
+		return l * r, true
+	case tokSlash:
+		if r == 0 {
+			return nil, false
+		}
+
+		return l / r, true
+	case tokPercent:
+		if r == 0 {
+			return nil, false
+		}
+
+		return math.Mod(l, r), true
+	}
+
+	return nil, false
+}
+
+// evaluateNeg evaluates a unary minus, and whether its result is present.
+func evaluateNeg(n *negNode, on *objNode) (interface{}, bool) {
+	val, ok := getValue(n.operand, on)
+	if !ok {
+		return nil, false
+	}
+
+	val, ok = arithNumber(val)
+	if !ok {
+		return nil, false
+	}
+
+	switch v := val.(type) {
+	case int64:
+		if v == math.MinInt64 {
+			return -float64(v), true
+		}
+
+		return -v, true
+	default:
+		return -v.(float64), true
+	}
+}
+
+// toFloat converts a number returned by arithNumber to float64.
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+
+	return v.(float64)
+}
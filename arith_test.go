@@ -0,0 +1,77 @@
+package haddoque
+
+import (
+	"math"
+	"testing"
+)
+
+var arithTests = []struct {
+	op    token
+	l, r  interface{}
+	res   interface{}
+	found bool
+}{
+	{tokPlus, int64(1), int64(2), int64(3), true},
+	{tokPlus, int64(1), 0.5, 1.5, true},
+	{tokPlus, "foo", "bar", "foobar", true},
+	{tokPlus, "foo", int64(1), nil, false},
+	{tokPlus, int64(math.MaxInt64), int64(1), float64(math.MaxInt64) + 1, true},
+	{tokMinus, int64(math.MinInt64), int64(1), float64(math.MinInt64) - 1, true},
+	{tokMinus, "foo", "o", nil, false},
+	{tokStar, int64(6), int64(7), int64(42), true},
+	{tokStar, int64(math.MaxInt64), int64(2), float64(math.MaxInt64) * 2, true},
+	{tokStar, int64(math.MinInt64), int64(-1), -float64(math.MinInt64), true},
+	{tokSlash, int64(7), int64(2), int64(3), true},
+	{tokSlash, int64(-7), int64(2), int64(-3), true},
+	{tokSlash, 7.0, int64(2), 3.5, true},
+	{tokSlash, int64(7), int64(0), nil, false},
+	{tokSlash, 7.0, 0.0, nil, false},
+	{tokSlash, int64(math.MinInt64), int64(-1), -float64(math.MinInt64), true},
+	{tokPercent, int64(7), int64(3), int64(1), true},
+	{tokPercent, int64(-7), int64(3), int64(-1), true},
+	{tokPercent, 7.5, int64(2), 1.5, true},
+	{tokPercent, int64(7), int64(0), nil, false},
+	{tokPlus, nil, int64(1), nil, false},
+	{tokPlus, true, int64(1), nil, false},
+}
+
+func TestArith(t *testing.T) {
+	for _, test := range arithTests {
+		on := newObjNode(map[string]interface{}{"l": test.l, "r": test.r})
+		n := &arithNode{
+			nodeType: nodeArith,
+			left:     &chainNode{nodeType: nodeChain, steps: []step{{kind: stepField, name: "l"}}},
+			right:    &chainNode{nodeType: nodeChain, steps: []step{{kind: stepField, name: "r"}}},
+			operator: test.op,
+		}
+
+		res, found := evaluateArith(n, on)
+		equals(t, test.found, found)
+		equals(t, test.res, res)
+	}
+}
+
+func TestNeg(t *testing.T) {
+	on := newObjNode(map[string]interface{}{"a": int64(2), "b": 1.5, "c": "foo", "d": int64(math.MinInt64)})
+
+	for _, test := range []struct {
+		chain string
+		res   interface{}
+		found bool
+	}{
+		{"a", int64(-2), true},
+		{"b", -1.5, true},
+		{"c", nil, false},
+		{"d", -float64(math.MinInt64), true},
+		{"e", nil, false},
+	} {
+		n := &negNode{
+			nodeType: nodeNeg,
+			operand:  &chainNode{nodeType: nodeChain, steps: []step{{kind: stepField, name: test.chain}}},
+		}
+
+		res, found := evaluateNeg(n, on)
+		equals(t, test.found, found)
+		equals(t, test.res, res)
+	}
+}
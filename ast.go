@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/big"
+	"reflect"
+	"regexp"
 	"strings"
 )
 
@@ -22,6 +25,23 @@ const (
 	nodeIn
 	nodeContains
 	nodeOperation
+	nodeQuantifier
+	nodeNot
+	nodeNull
+	nodeIsNull
+	nodeExists
+	nodeMatch
+	nodeCall
+	nodeArith
+	nodeNeg
+	nodeObject
+	nodeAggregate
+	nodeGroup
+	nodeOrder
+	nodeLimit
+	nodeDistinct
+	nodeComputed
+	nodeAlias
 )
 
 func (t nodeType) typ() nodeType {
@@ -49,16 +69,87 @@ func newSeqNode() *seqNode {
 	return &seqNode{nodeType: nodeSeq}
 }
 
+// objectNode represents an object literal
+type objectNode struct {
+	nodeType
+	keys   []string
+	values []node
+}
+
+func (n *objectNode) String() string {
+	return fmt.Sprintf("objectNode{%s}", strings.Join(n.keys, ", "))
+}
+
 // chainNode represents a chain of fields
 type chainNode struct {
 	nodeType
-	chain string
+	chain    string
+	steps    []step
+	optional bool
 }
 
 func (c *chainNode) String() string {
 	return fmt.Sprintf("chainNode{%s}", c.chain)
 }
 
+// multi reports whether the chain can select more than one element.
+func (c *chainNode) multi() bool {
+	return c.firstMulti() < len(c.steps)
+}
+
+// firstMulti returns the index of the first step which can select more than one element,
+// or the number of steps if there is none.
+func (c *chainNode) firstMulti() int {
+	for i, s := range c.steps {
+		if s.kind == stepSlice || s.kind == stepWildcard {
+			return i
+		}
+	}
+
+	return len(c.steps)
+}
+
+// location returns the location in an object of the part of the chain before its first slice or wildcard,
+// made of map keys (string) and array indexes (int).
+//
+// The location can't be known if it depends on the length of an array, because of a negative index.
+func (c *chainNode) location() ([]interface{}, bool) {
+	var loc []interface{}
+	for _, s := range c.steps[:c.firstMulti()] {
+		switch {
+		case s.kind == stepField:
+			loc = append(loc, s.name)
+		case s.index >= 0:
+			loc = append(loc, s.index)
+		default:
+			return nil, false
+		}
+	}
+
+	return loc, true
+}
+
+type stepKind int
+
+const (
+	stepField    stepKind = iota // .name
+	stepIndex                    // [1] or [-1]
+	stepSlice                    // [1:3], [1:] or [:3]
+	stepWildcard                 // [*]
+)
+
+// step is a single element of a chain of fields
+type step struct {
+	kind stepKind
+	name string
+	// index is the index for stepIndex and the lower bound for stepSlice
+	index int
+	// hi is the upper bound for stepSlice
+	hi    int
+	hasLo bool
+	hasHi bool
+}
+
 // boolNode represents a boolean value - true or false
 type boolNode struct {
 	nodeType
@@ -69,10 +160,20 @@ func (n *boolNode) String() string {
 	return fmt.Sprintf("boolNode{%v}", n.val)
 }
 
+// nullNode represents the null value
+type nullNode struct {
+	nodeType
+}
+
+func (n *nullNode) String() string {
+	return "nullNode"
+}
+
 // textNode represents a text value - string or char
 type textNode struct {
 	nodeType
-	text string
+	text string // quoted text as it appears in the query
+	val  string // unquoted value
 }
 
 func (n *textNode) String() string {
@@ -80,15 +181,21 @@ func (n *textNode) String() string {
 }
 
 // numberNode represents a number value - float or int
+//
+// An integer which doesn't fit in an int64 is kept in bigVal.
 type numberNode struct {
 	nodeType
 	isInt    bool
 	isFloat  bool
 	intVal   int64
+	bigVal   *big.Int
 	floatVal float64
 }
 
 func (n *numberNode) String() string {
+	if n.bigVal != nil {
+		return fmt.Sprintf("nodeNumber{int: %s}", n.bigVal)
+	}
 	if n.isInt {
 		return fmt.Sprintf("nodeNumber{int: %d}", n.intVal)
 	}
@@ -161,6 +268,187 @@ func (n *containsNode) String() string {
 	return fmt.Sprintf("containsNode")
 }
 
+// notNode represents a unary NOT expression
+type notNode struct {
+	nodeType
+	condition node
+}
+
+func (n *notNode) String() string {
+	return "notNode"
+}
+
+// isNullNode represents an IS NULL or IS NOT NULL expression
+type isNullNode struct {
+	nodeType
+	left   node
+	negate bool
+}
+
+func (n *isNullNode) String() string {
+	if n.negate {
+		return "isNullNode{not}"
+	}
+	return "isNullNode"
+}
+
+// existsNode represents an EXISTS expression, true if the field is present even if it's null
+type existsNode struct {
+	nodeType
+	chain node
+}
+
+func (n *existsNode) String() string {
+	return "existsNode"
+}
+
+// matchNode represents a pattern matching expression - MATCHES with a regular expression or LIKE with a glob
+type matchNode struct {
+	nodeType
+	left     node
+	operator token
+	pattern  string
+	re       *regexp.Regexp
+}
+
+func (n *matchNode) String() string {
+	return fmt.Sprintf("matchNode{%s %q}", n.operator, n.pattern)
+}
+
+// callNode represents a function call
+type callNode struct {
+	nodeType
+	name string
+	text string // source text of the whole call, used as the key of a computed field
+	args []node
+	fn   reflect.Value
+}
+
+func (n *callNode) String() string {
+	return fmt.Sprintf("callNode{%s}", n.name)
+}
+
+// arithNode represents a binary arithmetic expression - +, -, *, / or %
+type arithNode struct {
+	nodeType
+	left     node
+	right    node
+	operator token
+}
+
+func (n *arithNode) String() string {
+	return fmt.Sprintf("arithNode{%s}", n.operator)
+}
+
+// negNode represents a unary minus applied to something else than a number literal
+type negNode struct {
+	nodeType
+	operand node
+}
+
+func (n *negNode) String() string {
+	return "negNode"
+}
+
+// aggregateNode represents an aggregate function computed over a group of objects - count, sum, avg, min or max
+type aggregateNode struct {
+	nodeType
+	name string
+	text string // source text of the whole call, used as the key of the result
+	arg  node   // nil for count()
+	cond bool   // arg is a condition, count counts the objects satisfying it
+}
+
+func (n *aggregateNode) String() string {
+	return fmt.Sprintf("aggregateNode{%s}", n.name)
+}
+
+// groupNode represents a GROUP BY clause
+type groupNode struct {
+	nodeType
+	keys []node
+}
+
+func (n *groupNode) String() string {
+	return "groupNode"
+}
+
+// orderNode represents an ORDER BY clause
+type orderNode struct {
+	nodeType
+	keys []node
+	desc []bool // whether each key is in descending order
+}
+
+func (n *orderNode) String() string {
+	return fmt.Sprintf("orderNode{%v}", n.desc)
+}
+
+// limitNode represents a LIMIT or OFFSET clause
+type limitNode struct {
+	nodeType
+	clause token
+	n      int
+}
+
+func (n *limitNode) String() string {
+	return fmt.Sprintf("limitNode{%s %d}", n.clause, n.n)
+}
+
+// distinctNode represents a DISTINCT or DISTINCT ON clause
+type distinctNode struct {
+	nodeType
+	keys []node // nil for DISTINCT, where the key is the result
+}
+
+func (n *distinctNode) String() string {
+	return "distinctNode"
+}
+
+// computedNode represents a computed field, an arithmetic expression in the field selector
+type computedNode struct {
+	nodeType
+	expr node
+	text string // source text of the expression, used as the key of the result
+}
+
+func (n *computedNode) String() string {
+	return fmt.Sprintf("computedNode{%s}", n.text)
+}
+
+// aliasNode represents a selected field renamed with AS
+type aliasNode struct {
+	nodeType
+	expr node     // a chain, a call, an aggregate or a computed field
+	path []string // location of the field in the result
+}
+
+func (n *aliasNode) String() string {
+	return fmt.Sprintf("aliasNode{%s}", strings.Join(n.path, "."))
+}
+
+// location returns the location of the field in the result, like chainNode.location.
+func (n *aliasNode) location() []interface{} {
+	loc := make([]interface{}, len(n.path))
+	for i, name := range n.path {
+		loc[i] = name
+	}
+
+	return loc
+}
+
+// quantifierNode represents a quantified condition over the elements of an array - any, all or none
+type quantifierNode struct {
+	nodeType
+	quantifier token
+	seq        node
+	condition  node
+}
+
+func (n *quantifierNode) String() string {
+	return fmt.Sprintf("quantifierNode{%s}", n.quantifier)
+}
+
 // printIndentRoot prints an indented representation of the root
 func printIndentRoot(root *seqNode) string {
 	var buf bytes.Buffer
@@ -197,5 +485,47 @@ func printIndent(w io.Writer, root node, indent int) {
 	case *operationNode:
 		printIndent(w, v.left, indent+1)
 		printIndent(w, v.right, indent+1)
+	case *quantifierNode:
+		printIndent(w, v.seq, indent+1)
+		printIndent(w, v.condition, indent+1)
+	case *notNode:
+		printIndent(w, v.condition, indent+1)
+	case *isNullNode:
+		printIndent(w, v.left, indent+1)
+	case *existsNode:
+		printIndent(w, v.chain, indent+1)
+	case *matchNode:
+		printIndent(w, v.left, indent+1)
+	case *callNode:
+		for _, el := range v.args {
+			printIndent(w, el, indent+1)
+		}
+	case *arithNode:
+		printIndent(w, v.left, indent+1)
+		printIndent(w, v.right, indent+1)
+	case *negNode:
+		printIndent(w, v.operand, indent+1)
+	case *objectNode:
+		for _, el := range v.values {
+			printIndent(w, el, indent+1)
+		}
+	case *aggregateNode:
+		printIndent(w, v.arg, indent+1)
+	case *groupNode:
+		for _, el := range v.keys {
+			printIndent(w, el, indent+1)
+		}
+	case *orderNode:
+		for _, el := range v.keys {
+			printIndent(w, el, indent+1)
+		}
+	case *distinctNode:
+		for _, el := range v.keys {
+			printIndent(w, el, indent+1)
+		}
+	case *computedNode:
+		printIndent(w, v.expr, indent+1)
+	case *aliasNode:
+		printIndent(w, v.expr, indent+1)
 	}
 }
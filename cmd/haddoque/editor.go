@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keys handled by the line editor.
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyTab       = '\t'
+	keyEnter     = '\r'
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// lineEditor reads lines from a terminal in raw mode, echoing the keys pressed and completing the text
+// at the end of the line with the tab key.
+type lineEditor struct {
+	in     *bufio.Reader
+	out    io.Writer
+	prompt string
+	// complete returns the completions of the word starting at start in text.
+	complete func(text string) (start int, completions []string)
+
+	line []rune
+}
+
+// readLine prints the prompt and returns the line entered, or io.EOF once the input is exhausted
+// or on Ctrl-D with an empty line.
+func (e *lineEditor) readLine() (string, error) {
+	e.line = e.line[:0]
+	fmt.Fprint(e.out, e.prompt)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err == io.EOF && len(e.line) > 0 {
+			fmt.Fprintln(e.out)
+			return string(e.line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			fmt.Fprintln(e.out)
+			return string(e.line), nil
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Fprintln(e.out)
+				return "", io.EOF
+			}
+		case keyCtrlC:
+			fmt.Fprintln(e.out, "^C")
+			e.line = e.line[:0]
+			fmt.Fprint(e.out, e.prompt)
+		case keyCtrlU:
+			fmt.Fprint(e.out, strings.Repeat("\b \b", len(e.line)))
+			e.line = e.line[:0]
+		case keyBackspace, keyDelete:
+			if len(e.line) > 0 {
+				e.line = e.line[:len(e.line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+		case keyTab:
+			e.completeLine()
+		case keyEscape:
+			e.skipEscape()
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				e.line = append(e.line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// completeLine completes the word at the end of the line with the common prefix of its completions.
+// If the word is already the common prefix, the completions are listed and the line is printed again.
+func (e *lineEditor) completeLine() {
+	text := string(e.line)
+	start, completions := e.complete(text)
+	if len(completions) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+
+	prefix := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+
+	if word := text[start:]; len(prefix) > len(word) {
+		added := prefix[len(word):]
+		e.line = append(e.line, []rune(added)...)
+		fmt.Fprint(e.out, added)
+		return
+	}
+
+	fmt.Fprintln(e.out)
+	for _, c := range completions {
+		fmt.Fprintln(e.out, c)
+	}
+	fmt.Fprint(e.out, e.prompt+text)
+}
+
+// skipEscape skips the escape sequence of a key without effect, such as an arrow key.
+func (e *lineEditor) skipEscape() {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+
+	// the sequence ends with a byte in the range @ to ~, after the parameters
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil || (b >= '@' && b <= '~') {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	r := &repl{paths: []string{".id", ".type", ".user", ".user.name", ".user.tags", ".user.tags[0]", ".users"}}
+
+	input := strings.Join([]string{
+		".idx\x7f where .t\t == 1\r",     // backspace and unique completion
+		".id where .us\t\ts\r",           // common prefix, then list of completions
+		".x\x1b[A\x1bOB\x1b[1;5C\t\r",    // escape sequences are skipped, no completion
+		"abc\x03.user.\t\x15.user.n\t\n", // Ctrl-C, list of completions and Ctrl-U
+		"\x04",
+	}, "")
+
+	var out bytes.Buffer
+	e := &lineEditor{in: bufio.NewReader(strings.NewReader(input)), out: &out, prompt: "> ", complete: r.completions}
+
+	var lines []string
+	for {
+		line, err := e.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	exp := []string{
+		`.id where .type == 1`,
+		`.id where .users`,
+		`.x`,
+		`.user.name`,
+	}
+	if !reflect.DeepEqual(exp, lines) {
+		t.Errorf("expected the lines %q, got %q", exp, lines)
+	}
+
+	expOut := "> .idx\b \b where .type == 1\n" +
+		"> .id where .user\n.user.name\n.user.tags\n.users\n> .id where .users\n" +
+		"> .x\a\n" +
+		"> abc^C\n> .user.\n.user.name\n.user.tags\n> .user.\b \b\b \b\b \b\b \b\b \b\b \b.user.name\n" +
+		"> \n"
+	if out.String() != expOut {
+		t.Errorf("expected the output:\n%q\ngot:\n%q", expOut, out.String())
+	}
+}
+
+func TestLineEditorUnicode(t *testing.T) {
+	// the names share the first byte of their last rune
+	r := &repl{paths: []string{".café", ".cafè"}}
+
+	var out bytes.Buffer
+	e := &lineEditor{in: bufio.NewReader(strings.NewReader(".c\t\r")), out: &out, prompt: "> ", complete: r.completions}
+
+	line, err := e.readLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != ".caf" {
+		t.Errorf("expected the line %q, got %q", ".caf", line)
+	}
+	if exp := "> .caf\n"; out.String() != exp {
+		t.Errorf("expected the output %q, got %q", exp, out.String())
+	}
+}
+
+func TestLineEditorEOF(t *testing.T) {
+	var out bytes.Buffer
+	e := &lineEditor{in: bufio.NewReader(strings.NewReader(".id")), out: &out, prompt: "> ", complete: (&repl{}).completions}
+
+	line, err := e.readLine()
+	if line != ".id" || err != nil {
+		t.Errorf("expected the last line without newline, got %q, %v", line, err)
+	}
+
+	if _, err := e.readLine(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+// Command haddoque executes a query on JSON documents, like grep does on lines of text.
+//
+// Usage:
+//
+//	haddoque [flags] <query> [files...]
+//
+// The documents are read from the files, or from the standard input if there are none or if a file is "-".
+// They can be newline-delimited JSON, concatenated JSON values or JSON arrays, whose elements are queried separately.
+//
+// The flags are:
+//
+//	-format ndjson|compact|pretty
+//		output format of the results: one per line, a single JSON array, or an indented JSON array (default ndjson)
+//	-count, -c
+//		only print the number of matching documents
+//	-first N, -n N
+//		stop after N matching documents
+//	-invert, -v
+//		select the documents which don't match, and print them entirely
+//	-missing error|null|omit
+//		handling of the selected fields missing from a document: make it invalid, set them to null,
+//		or leave them out of the result (default omit)
+//	-distinct-capacity N
+//		with a distinct query, only remember the N most recently seen keys
+//	-distinct-window D
+//		with a distinct query, forget the keys not seen for the duration D, like 10m
+//
+// The exit status is 0 if a document was selected, 1 if none was, and 2 if an error occurred.
+//
+// With an aggregate query, the result of each group is printed once all the documents are read,
+// and -count prints the number of groups. Likewise, the results of a query with order by, limit or offset
+// clauses are printed once all the documents are read, or once the limit is reached without order by clause.
+// With a distinct query, only the first of the documents with the same keys is selected; all the keys
+// are remembered unless bounded by -distinct-capacity or -distinct-window.
+//
+// # Interactive mode
+//
+// To write a query, the interactive mode executes each line read on sample documents:
+//
+//	haddoque repl -sample <file> [-sample <file>...] [-history file]
+//
+// The sample files have the same formats as the documents. In a terminal, the tab key completes the path
+// of the samples being typed, or lists its completions when pressed again; they're also listed with
+// the :complete command. The lines are kept in the history file, ~/.haddoque_history by default.
+// Type :help in the interactive mode for the list of commands.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vrischmann/haddoque"
+)
+
+const (
+	exitSelected = 0
+	exitNone     = 1
+	exitError    = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type options struct {
+	format           string
+	count            bool
+	first            int
+	invert           bool
+	missing          string
+	distinctCapacity int
+	distinctWindow   time.Duration
+}
+
+// run runs the command with the arguments args and returns its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "repl" {
+		return runREPL(args[1:], stdin, stdout, stderr)
+	}
+
+	var opts options
+
+	fs := flag.NewFlagSet("haddoque", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: haddoque [flags] <query> [files...]")
+		fmt.Fprintln(stderr, "       haddoque repl -sample <file> [flags]")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.format, "format", "ndjson", "output `format`: ndjson, compact or pretty")
+	fs.BoolVar(&opts.count, "count", false, "only print the number of matching documents")
+	fs.BoolVar(&opts.count, "c", false, "shorthand for -count")
+	fs.IntVar(&opts.first, "first", 0, "stop after `N` matching documents")
+	fs.IntVar(&opts.first, "n", 0, "shorthand for -first")
+	fs.BoolVar(&opts.invert, "invert", false, "select the documents which don't match")
+	fs.BoolVar(&opts.invert, "v", false, "shorthand for -invert")
+	fs.StringVar(&opts.missing, "missing", "omit", "handling of the selected fields missing from a document: `mode` error, null or omit")
+	fs.IntVar(&opts.distinctCapacity, "distinct-capacity", 0, "remember at most `N` keys of a distinct query")
+	fs.DurationVar(&opts.distinctWindow, "distinct-window", 0, "forget the keys of a distinct query not seen for `duration`")
+
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	switch opts.format {
+	case "ndjson", "compact", "pretty":
+	default:
+		fmt.Fprintf(stderr, "haddoque: unknown format %q\n", opts.format)
+		return exitError
+	}
+
+	var missing haddoque.MissingFields
+	switch opts.missing {
+	case "error":
+		missing = haddoque.MissingError
+	case "null":
+		missing = haddoque.MissingAsNull
+	case "omit":
+		missing = haddoque.MissingOmit
+	default:
+		fmt.Fprintf(stderr, "haddoque: unknown missing fields mode %q\n", opts.missing)
+		return exitError
+	}
+
+	q, err := haddoque.Compile(fs.Arg(0), haddoque.WithMissingFields(missing))
+	if err != nil {
+		var serr *haddoque.SyntaxError
+		if errors.As(err, &serr) {
+			fmt.Fprint(stderr, serr.Format())
+		} else {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+		}
+		return exitError
+	}
+
+	files := fs.Args()[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	out := newOutput(stdout, opts.format)
+
+	var selected int
+	var failed bool
+	switch {
+	case q.IsAggregate():
+		if opts.invert || opts.first > 0 {
+			fmt.Fprintln(stderr, "haddoque: -invert and -first can't be used with aggregates")
+			return exitError
+		}
+
+		agg, err := haddoque.NewAggregator(q)
+		if err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			return exitError
+		}
+
+		selected, failed = collectFiles(files, stdin, stderr, out, agg, opts)
+	case q.IsCollection():
+		if opts.invert {
+			fmt.Fprintln(stderr, "haddoque: -invert can't be used with order by, limit or offset")
+			return exitError
+		}
+
+		c, err := haddoque.NewCollector(q, haddoque.WithDistinctBounds(opts.distinctCapacity, opts.distinctWindow))
+		if err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			return exitError
+		}
+
+		selected, failed = collectFiles(files, stdin, stderr, out, c, opts)
+	default:
+		var d *haddoque.Deduplicator
+		if q.IsDistinct() {
+			if opts.invert {
+				fmt.Fprintln(stderr, "haddoque: -invert can't be used with distinct")
+				return exitError
+			}
+
+			d, _ = haddoque.NewDeduplicator(q, opts.distinctCapacity, opts.distinctWindow)
+		}
+
+		for _, name := range files {
+			n, ok := filterFile(name, stdin, stderr, out, q, d, opts, selected)
+			selected += n
+			failed = failed || !ok
+
+			if opts.first > 0 && selected >= opts.first {
+				break
+			}
+		}
+	}
+
+	if opts.count {
+		fmt.Fprintln(out.w, selected)
+	} else {
+		out.close()
+	}
+
+	if err := out.w.Flush(); err != nil {
+		fmt.Fprintf(stderr, "haddoque: %v\n", err)
+		return exitError
+	}
+
+	switch {
+	case failed:
+		return exitError
+	case selected > 0:
+		return exitSelected
+	default:
+		return exitNone
+	}
+}
+
+// openFile opens the file name, or returns stdin for "-". The name to use in messages is returned with it.
+func openFile(name string, stdin io.Reader) (io.ReadCloser, string, error) {
+	if name == "-" {
+		return io.NopCloser(stdin), "<stdin>", nil
+	}
+
+	f, err := os.Open(name)
+	return f, name, err
+}
+
+// collector is implemented by haddoque.Aggregator and haddoque.Collector.
+type collector interface {
+	AddStream(r io.Reader) error
+	Results() []interface{}
+}
+
+// collectFiles adds the documents of all the files to the collector, and writes its results,
+// the first ones only with -first.
+//
+// It returns the number of results, and true if an error occurred.
+func collectFiles(files []string, stdin io.Reader, stderr io.Writer, out *output, c collector, opts options) (int, bool) {
+	failed := false
+	for _, name := range files {
+		r, name, err := openFile(name, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			failed = true
+			continue
+		}
+
+		err = c.AddStream(r)
+		r.Close()
+
+		var errs haddoque.RecordErrors
+		switch {
+		case errors.As(err, &errs):
+			for _, rerr := range errs.Errors {
+				fmt.Fprintf(stderr, "haddoque: %s:%d: %v\n", name, rerr.Line, rerr.Err)
+			}
+			if errs.Dropped > 0 {
+				fmt.Fprintf(stderr, "haddoque: %s: %d more invalid records\n", name, errs.Dropped)
+			}
+			failed = true
+		case err != nil:
+			fmt.Fprintf(stderr, "haddoque: %s: %v\n", name, err)
+			failed = true
+		}
+	}
+
+	results := c.Results()
+	if opts.first > 0 && len(results) > opts.first {
+		results = results[:opts.first]
+	}
+
+	if !opts.count {
+		for _, res := range results {
+			if err := out.write(res); err != nil {
+				fmt.Fprintf(stderr, "haddoque: %v\n", err)
+				return len(results), true
+			}
+		}
+	}
+
+	return len(results), failed
+}
+
+// filterFile executes the query on the documents of a file, already selected documents being counted in previous.
+// The duplicates are skipped with d for a distinct query.
+//
+// It returns the number of documents selected, and false if an error occurred.
+func filterFile(name string, stdin io.Reader, stderr io.Writer, out *output, q *haddoque.Query, d *haddoque.Deduplicator, opts options, previous int) (int, bool) {
+	r, name, err := openFile(name, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "haddoque: %v\n", err)
+		return 0, false
+	}
+	defer r.Close()
+
+	selected, ok := 0, true
+
+	var it *haddoque.Iterator
+	if d != nil {
+		it = d.Iterate(r)
+	} else {
+		it = haddoque.NewIterator(r, q)
+	}
+
+	for it.Next() {
+		rec := it.Record()
+
+		var rerr *haddoque.RecordError
+		if errors.As(rec.Err, &rerr) {
+			fmt.Fprintf(stderr, "haddoque: %s:%d: %v\n", name, rerr.Line, rerr.Err)
+			ok = false
+			continue
+		}
+
+		if rec.Matched == opts.invert {
+			continue
+		}
+		selected++
+
+		if !opts.count {
+			var err error
+			if opts.invert {
+				err = out.writeRaw(rec.Raw)
+			} else {
+				err = out.write(rec.Result)
+			}
+
+			if err != nil {
+				fmt.Fprintf(stderr, "haddoque: %s:%d: %v\n", name, rec.Line, err)
+				return selected, false
+			}
+		}
+
+		if opts.first > 0 && previous+selected >= opts.first {
+			break
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		fmt.Fprintf(stderr, "haddoque: %s: %v\n", name, err)
+		return selected, false
+	}
+
+	return selected, ok
+}
+
+// output writes the results in one of the output formats.
+type output struct {
+	w      *bufio.Writer
+	format string
+	n      int
+}
+
+func newOutput(w io.Writer, format string) *output {
+	return &output{
+		w:      bufio.NewWriter(w),
+		format: format,
+	}
+}
+
+// write writes a result.
+func (o *output) write(v interface{}) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	return o.writeRaw(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// writeRaw writes a JSON value.
+func (o *output) writeRaw(raw []byte) error {
+	var buf bytes.Buffer
+
+	switch o.format {
+	case "pretty":
+		if err := json.Indent(&buf, raw, "  ", "  "); err != nil {
+			return err
+		}
+	default:
+		if err := json.Compact(&buf, raw); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case o.format == "ndjson":
+	case o.n == 0 && o.format == "pretty":
+		o.w.WriteString("[\n  ")
+	case o.n == 0:
+		o.w.WriteString("[")
+	case o.format == "pretty":
+		o.w.WriteString(",\n  ")
+	default:
+		o.w.WriteString(",")
+	}
+	o.n++
+
+	o.w.Write(buf.Bytes())
+	if o.format == "ndjson" {
+		o.w.WriteString("\n")
+	}
+
+	return nil
+}
+
+// close ends the output.
+func (o *output) close() {
+	switch {
+	case o.format == "ndjson":
+	case o.n == 0:
+		o.w.WriteString("[]\n")
+	case o.format == "pretty":
+		o.w.WriteString("\n]\n")
+	default:
+		o.w.WriteString("]\n")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testInput = `{"id": 1, "type": "click"}
+{"id": 2, "type": "view"}
+{"id": 3, "type": "click"}
+`
+
+func runTest(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		args   []string
+		input  string
+		code   int
+		stdout string
+	}{
+		{[]string{`.id where .type == "click"`}, testInput, exitSelected, "{\"id\":1}\n{\"id\":3}\n"},
+		{[]string{`.id where .type == "none"`}, testInput, exitNone, ""},
+		{[]string{"-format", "compact", `.id where .type == "click"`}, testInput, exitSelected, "[{\"id\":1},{\"id\":3}]\n"},
+		{[]string{"-format", "compact", `.id where .type == "none"`}, testInput, exitNone, "[]\n"},
+		{[]string{"-format", "pretty", `.id where .type == "click"`}, testInput, exitSelected, "[\n  {\n    \"id\": 1\n  },\n  {\n    \"id\": 3\n  }\n]\n"},
+		{[]string{"-count", `.id where .type == "click"`}, testInput, exitSelected, "2\n"},
+		{[]string{"-c", `.id where .type == "none"`}, testInput, exitNone, "0\n"},
+		{[]string{"-first", "1", `.id where .type == "click"`}, testInput, exitSelected, "{\"id\":1}\n"},
+		{[]string{"-n", "1", "-c", `.id where .type == "click"`}, testInput, exitSelected, "1\n"},
+		{[]string{"--invert", `.id where .type == "click"`}, testInput, exitSelected, "{\"id\":2,\"type\":\"view\"}\n"},
+		{[]string{"-v", `.id where .id > 0`}, testInput, exitNone, ""},
+		{[]string{`.id, .type where .type == "click"`}, `{"type": "click"} {"id": 2, "type": "click"}`, exitSelected, "{\"type\":\"click\"}\n{\"id\":2,\"type\":\"click\"}\n"},
+		{[]string{"-missing", "null", `.id, .type where .type == "click"`}, `{"type": "click"}`, exitSelected, "{\"id\":null,\"type\":\"click\"}\n"},
+		{[]string{"-v", `.id where .type == "click"`}, `{"name": "x"}`, exitSelected, "{\"name\":\"x\"}\n"},
+		{[]string{"-missing", "error", `.id`}, `{"name": "x"}`, exitError, ""},
+		{[]string{`.name`}, `{"name": "<a & b>"}`, exitSelected, "{\"name\":\"<a & b>\"}\n"},
+		{[]string{`count() group by .type`}, testInput, exitSelected, "{\"count()\":2,\"type\":\"click\"}\n{\"count()\":1,\"type\":\"view\"}\n"},
+		{[]string{"-format", "compact", `sum(.id), max(.type)`}, testInput, exitSelected, "[{\"max(.type)\":\"view\",\"sum(.id)\":6}]\n"},
+		{[]string{"-count", `count() group by .type`}, testInput, exitSelected, "2\n"},
+		{[]string{`count() group by .type where .id > 3`}, testInput, exitNone, ""},
+		{[]string{`.id order by .id desc limit 2`}, testInput, exitSelected, "{\"id\":3}\n{\"id\":2}\n"},
+		{[]string{"-format", "compact", `.id where .type == "click" offset 1`}, testInput, exitSelected, "[{\"id\":3}]\n"},
+		{[]string{"-first", "1", `.id order by .type desc, .id`}, testInput, exitSelected, "{\"id\":2}\n"},
+		{[]string{"-count", `.id limit 2`}, testInput, exitSelected, "2\n"},
+		{[]string{`select .id as n, .id * 10 as out.m where .type == "view"`}, testInput, exitSelected, "{\"n\":2,\"out\":{\"m\":20}}\n"},
+		{[]string{`distinct .type`}, testInput, exitSelected, "{\"type\":\"click\"}\n{\"type\":\"view\"}\n"},
+		{[]string{"-distinct-capacity", "1", `distinct on (.type) .id`}, testInput + testInput, exitSelected, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":2}\n{\"id\":3}\n"},
+		{[]string{"-distinct-capacity", "1", `distinct on (.type) .id limit 10`}, testInput + testInput, exitSelected, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":2}\n{\"id\":3}\n"},
+		{[]string{"-distinct-capacity", "1", `distinct on (.type) .id order by .id desc`}, testInput + testInput, exitSelected, "{\"id\":3}\n{\"id\":3}\n{\"id\":2}\n{\"id\":2}\n{\"id\":1}\n"},
+		{[]string{"-distinct-window", "1h", "-c", `distinct on (.type) .id`}, testInput + testInput, exitSelected, "2\n"},
+		{[]string{`distinct on (.type) .id order by .id desc`}, testInput, exitSelected, "{\"id\":2}\n{\"id\":1}\n"},
+		{[]string{`count() group by .type order by count() limit 1`}, testInput, exitSelected, "{\"count()\":1,\"type\":\"view\"}\n"},
+	}
+
+	for _, tc := range testCases {
+		code, stdout, stderr := runTest(t, tc.input, tc.args...)
+		if code != tc.code {
+			t.Errorf("%v: expected exit status %d, got %d (stderr: %q)", tc.args, tc.code, code, stderr)
+		}
+		if stdout != tc.stdout {
+			t.Errorf("%v: expected output %q, got %q", tc.args, tc.stdout, stdout)
+		}
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	if err := os.WriteFile(a, []byte(`[{"id": 1}, {"id": 2}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte(`{"id": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, _ := runTest(t, `{"id": 4}`, `.id where .id != 2`, a, "-", b)
+	if code != exitSelected {
+		t.Errorf("expected exit status %d, got %d", exitSelected, code)
+	}
+	if exp := "{\"id\":1}\n{\"id\":4}\n{\"id\":3}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+
+	// -first applies across all files
+	_, stdout, _ = runTest(t, "", "-first", "2", `.id where .id > 0`, a, b)
+	if exp := "{\"id\":1}\n{\"id\":2}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+
+	// aggregates are computed over all files
+	_, stdout, _ = runTest(t, `{"id": 4}`, `count(), sum(.id)`, a, "-", b)
+	if exp := "{\"count()\":4,\"sum(.id)\":10}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+
+	code, stdout, stderr := runTest(t, "", `.id`, filepath.Join(dir, "missing.json"), b)
+	if code != exitError {
+		t.Errorf("expected exit status %d, got %d", exitError, code)
+	}
+	if exp := "{\"id\":3}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+	if !strings.Contains(stderr, "missing.json") {
+		t.Errorf("expected the missing file in %q", stderr)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	code, stdout, stderr := runTest(t, "{\"id\": 1}\n{\"id\": 2]\n{\"id\": 3}\n", `.id`)
+	if code != exitError {
+		t.Errorf("expected exit status %d, got %d", exitError, code)
+	}
+	if exp := "{\"id\":1}\n{\"id\":3}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+	if !strings.HasPrefix(stderr, "haddoque: <stdin>:2: ") {
+		t.Errorf("expected the line of the invalid record in %q", stderr)
+	}
+
+	code, stdout, stderr = runTest(t, "{\"id\": 1}\n{\"id\": 2]\n{\"id\": 3}\n", `count()`)
+	if code != exitError {
+		t.Errorf("expected exit status %d, got %d", exitError, code)
+	}
+	if exp := "{\"count()\":2}\n"; stdout != exp {
+		t.Errorf("expected output %q, got %q", exp, stdout)
+	}
+	if !strings.HasPrefix(stderr, "haddoque: <stdin>:2: ") {
+		t.Errorf("expected the line of the invalid record in %q", stderr)
+	}
+
+	code, _, stderr = runTest(t, testInput, `.id where .type ==`)
+	if code != exitError {
+		t.Errorf("expected exit status %d, got %d", exitError, code)
+	}
+	if !strings.Contains(stderr, "^") {
+		t.Errorf("expected the position of the syntax error in %q", stderr)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-format", "xml", `.id`},
+		{"-unknown", `.id`},
+		{"-v", `count()`},
+		{"-first", "1", `count()`},
+		{"-v", `.id order by .id`},
+		{"-v", `distinct .id`},
+		{"-distinct-window", "forever", `distinct .id`},
+	} {
+		if code, _, _ := runTest(t, testInput, args...); code != exitError {
+			t.Errorf("%v: expected exit status %d, got %d", args, exitError, code)
+		}
+	}
+}
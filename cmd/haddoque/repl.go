@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vrischmann/haddoque"
+)
+
+const replHelp = `Enter a query to execute it on each sample, or a command:
+  :tree [query]       print the parsed tree of the query, or of the last one
+  :paths [prefix]     list the paths of the samples
+  :complete <text>    list the completions of the last path in the text
+  :samples            print the samples
+  :history            print the history
+  :help               print this help
+  :quit               exit
+The tab key completes the path being typed, or lists its completions when pressed again.
+!! repeats the last line of the history, !N repeats the line N.
+`
+
+// stringsFlag is a flag which can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// runREPL runs the interactive mode, where each line read is a query executed on sample documents.
+func runREPL(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var samples stringsFlag
+
+	fs := flag.NewFlagSet("haddoque repl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: haddoque repl -sample <file> [-sample <file>...] [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Var(&samples, "sample", "`file` of sample documents, can be repeated")
+	historyFile := fs.String("history", defaultHistoryFile(), "`file` where the history is kept, none if empty")
+
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+
+	if len(samples) == 0 || fs.NArg() > 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	r := &repl{out: stdout}
+	for _, name := range samples {
+		if err := r.loadSamples(name); err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			return exitError
+		}
+	}
+
+	if *historyFile != "" {
+		if err := r.openHistory(*historyFile); err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			return exitError
+		}
+		defer r.historyFile.Close()
+	}
+
+	fmt.Fprintf(stdout, "%d samples loaded, :help for help\n", len(r.samples))
+
+	readLine := scanLines(stdin, stdout)
+	if f, ok := stdin.(*os.File); ok {
+		if restore, err := makeRaw(f.Fd()); err == nil {
+			defer restore()
+
+			e := &lineEditor{in: bufio.NewReader(f), out: stdout, prompt: "> ", complete: r.completions}
+			readLine = e.readLine
+		}
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "haddoque: %v\n", err)
+			return exitError
+		}
+
+		if !r.handle(line) {
+			break
+		}
+	}
+
+	return exitSelected
+}
+
+// scanLines returns a function reading the lines of the input after printing the prompt,
+// when it isn't a terminal or the terminal can't be put in raw mode.
+func scanLines(stdin io.Reader, stdout io.Writer) func() (string, error) {
+	sc := bufio.NewScanner(stdin)
+
+	return func() (string, error) {
+		fmt.Fprint(stdout, "> ")
+		if sc.Scan() {
+			return sc.Text(), nil
+		}
+		if err := sc.Err(); err != nil {
+			return "", err
+		}
+
+		return "", io.EOF
+	}
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".haddoque_history")
+}
+
+type repl struct {
+	out         io.Writer
+	samples     []interface{}
+	paths       []string // all the paths of the samples, sorted
+	query       *haddoque.Query
+	history     []string
+	historyFile *os.File
+}
+
+// loadSamples reads the documents of a file.
+//
+// The file can contain concatenated or newline-delimited JSON values, or arrays whose elements are separate documents.
+func (r *repl) loadSamples(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if elems, ok := doc.([]interface{}); ok {
+			r.samples = append(r.samples, elems...)
+		} else {
+			r.samples = append(r.samples, doc)
+		}
+	}
+
+	seen := make(map[string]bool, len(r.paths))
+	for _, p := range r.paths {
+		seen[p] = true
+	}
+	for _, doc := range r.samples {
+		for _, p := range haddoque.Paths(doc) {
+			if !seen[p] {
+				r.paths = append(r.paths, p)
+				seen[p] = true
+			}
+		}
+	}
+	sort.Strings(r.paths)
+
+	return nil
+}
+
+// openHistory reads the history kept in the file, and opens it to add the next lines.
+func (r *repl) openHistory(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+
+	r.historyFile, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	return err
+}
+
+func (r *repl) addHistory(line string) {
+	r.history = append(r.history, line)
+
+	if r.historyFile == nil {
+		return
+	}
+
+	if _, err := fmt.Fprintln(r.historyFile, line); err != nil {
+		fmt.Fprintf(r.out, "history disabled: %v\n", err)
+		r.historyFile.Close()
+		r.historyFile = nil
+	}
+}
+
+// handle handles a line of input. It returns false to exit.
+func (r *repl) handle(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+
+	if strings.HasPrefix(line, "!") {
+		var ok bool
+		if line, ok = r.expandHistory(line); !ok {
+			return true
+		}
+		fmt.Fprintln(r.out, line)
+	}
+
+	r.addHistory(line)
+
+	if !strings.HasPrefix(line, ":") {
+		r.eval(line)
+		return true
+	}
+
+	cmd, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch cmd {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":tree":
+		r.tree(arg)
+	case ":paths":
+		for _, p := range r.paths {
+			if strings.HasPrefix(p, arg) {
+				fmt.Fprintln(r.out, p)
+			}
+		}
+	case ":complete":
+		r.complete(arg)
+	case ":samples":
+		for i, doc := range r.samples {
+			fmt.Fprintf(r.out, "[%d] %s\n", i+1, encodeJSON(doc))
+		}
+	case ":history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%5d  %s\n", i+1, h)
+		}
+	default:
+		fmt.Fprintf(r.out, "unknown command %s, :help for help\n", cmd)
+	}
+
+	return true
+}
+
+// expandHistory returns the line of the history referred to by !! or !N.
+func (r *repl) expandHistory(line string) (string, bool) {
+	n := len(r.history)
+	if line != "!!" {
+		var err error
+		if n, err = strconv.Atoi(line[1:]); err != nil {
+			fmt.Fprintf(r.out, "invalid history reference %s\n", line)
+			return "", false
+		}
+	}
+
+	if n < 1 || n > len(r.history) {
+		fmt.Fprintf(r.out, "no line %s in the history\n", line)
+		return "", false
+	}
+
+	return r.history[n-1], true
+}
+
+// eval executes a query on each sample, or on all of them for an aggregate query.
+func (r *repl) eval(text string) {
+	q, ok := r.compile(text)
+	if !ok {
+		return
+	}
+	r.query = q
+
+	switch {
+	case q.IsAggregate():
+		r.aggregate(q)
+		return
+	case q.IsCollection():
+		r.collect(q)
+		return
+	}
+
+	exec := q.ExecMatch
+	if q.IsDistinct() {
+		d, _ := haddoque.NewDeduplicator(q, 0, 0)
+		exec = d.Exec
+	}
+
+	matched := 0
+	for i, doc := range r.samples {
+		res, ok, err := exec(doc)
+		switch {
+		case err != nil:
+			fmt.Fprintf(r.out, "[%d] error: %v\n", i+1, err)
+		case ok:
+			fmt.Fprintf(r.out, "[%d] %s\n", i+1, encodeJSON(res))
+			matched++
+		}
+	}
+
+	fmt.Fprintf(r.out, "%d/%d samples matched\n", matched, len(r.samples))
+}
+
+// aggregate executes an aggregate query on all the samples.
+func (r *repl) aggregate(q *haddoque.Query) {
+	agg, err := haddoque.NewAggregator(q)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	for i, doc := range r.samples {
+		if err := agg.Add(doc); err != nil {
+			fmt.Fprintf(r.out, "[%d] error: %v\n", i+1, err)
+		}
+	}
+
+	results := agg.Results()
+	for _, res := range results {
+		fmt.Fprintln(r.out, encodeJSON(res))
+	}
+	fmt.Fprintf(r.out, "%d groups\n", len(results))
+}
+
+// collect executes a query with order by, limit or offset clauses on all the samples.
+func (r *repl) collect(q *haddoque.Query) {
+	c, err := haddoque.NewCollector(q)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	for i, doc := range r.samples {
+		if err := c.Add(doc); err != nil {
+			fmt.Fprintf(r.out, "[%d] error: %v\n", i+1, err)
+		}
+	}
+
+	results := c.Results()
+	for _, res := range results {
+		fmt.Fprintln(r.out, encodeJSON(res))
+	}
+	fmt.Fprintf(r.out, "%d results\n", len(results))
+}
+
+func (r *repl) compile(text string) (*haddoque.Query, bool) {
+	q, err := haddoque.Compile(text)
+	if err != nil {
+		var serr *haddoque.SyntaxError
+		if errors.As(err, &serr) {
+			fmt.Fprint(r.out, serr.Format())
+		} else {
+			fmt.Fprintln(r.out, err)
+		}
+		return nil, false
+	}
+
+	return q, true
+}
+
+// tree prints the parsed tree of a query, or of the last query executed.
+func (r *repl) tree(text string) {
+	q := r.query
+	if text != "" {
+		var ok bool
+		if q, ok = r.compile(text); !ok {
+			return
+		}
+	}
+
+	if q == nil {
+		fmt.Fprintln(r.out, "no query executed yet")
+		return
+	}
+
+	fmt.Fprint(r.out, q.Tree())
+}
+
+// complete prints the completions of the path at the end of the text.
+func (r *repl) complete(text string) {
+	start, completions := r.completions(text)
+	if len(completions) == 0 {
+		fmt.Fprintln(r.out, "no completion")
+		return
+	}
+
+	for _, c := range completions {
+		fmt.Fprintln(r.out, text[:start]+c)
+	}
+}
+
+// completions returns the completions of the path at the end of the text, which starts at start.
+func (r *repl) completions(text string) (int, []string) {
+	start := strings.LastIndexAny(text, " \t(),=<>!+-*/%") + 1
+
+	return start, completePath(r.paths, text[start:])
+}
+
+// completePath returns the paths completing word up to the end of their next field or index.
+func completePath(paths []string, word string) []string {
+	if word == "" {
+		word = "."
+	}
+	if !strings.HasPrefix(word, ".") {
+		return nil
+	}
+
+	var res []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if !strings.HasPrefix(p, word) || p == word {
+			continue
+		}
+
+		// stop at the field or index following the one being completed
+		rest := p[len(word):]
+		if i := strings.IndexAny(rest[1:], ".["); i >= 0 {
+			p = p[:len(word)+1+i]
+		}
+
+		if !seen[p] {
+			res = append(res, p)
+			seen[p] = true
+		}
+	}
+
+	return res
+}
+
+func encodeJSON(v interface{}) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
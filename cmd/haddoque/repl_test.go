@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSample(t *testing.T, dir string) string {
+	t.Helper()
+
+	name := filepath.Join(dir, "samples.json")
+	data := `{"id": 1, "user": {"name": "Vincent", "tags": ["a", "b"]}, "type": "click"}
+[{"id": 2, "user": {"name": "Jeremy"}, "type": "view"}, {"id": 3, "users": []}]
+`
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	sample := writeSample(t, dir)
+	history := filepath.Join(dir, "history")
+
+	input := strings.Join([]string{
+		`.id where .type == "click"`,
+		`.user.name`,
+		`.id where .type ==`,
+		`:tree`,
+		`:complete .user.`,
+		`!1`,
+		`:history`,
+		`:quit`,
+		`.id`,
+	}, "\n")
+
+	code, stdout, stderr := runTest(t, input, "repl", "-sample", sample, "-history", history)
+	if code != exitSelected {
+		t.Fatalf("expected exit status %d, got %d (stderr: %q)", exitSelected, code, stderr)
+	}
+
+	for _, exp := range []string{
+		"3 samples loaded",
+		"[1] {\"id\":1}\n1/3 samples matched\n",
+		"[1] {\"user\":{\"name\":\"Vincent\"}}\n[2] {\"user\":{\"name\":\"Jeremy\"}}\n[3] error: ",
+		"line 1, column ",
+		"listNode\n chainNode{.user.name}\n",
+		".user.name\n.user.tags\n",
+		"> .id where .type == \"click\"\n[1] {\"id\":1}\n",
+		"    1  .id where .type == \"click\"\n",
+		"    7  :history\n",
+	} {
+		if !strings.Contains(stdout, exp) {
+			t.Errorf("expected %q in the output:\n%s", exp, stdout)
+		}
+	}
+
+	// the lines after :quit are ignored and history references are expanded
+	data, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := strings.Join([]string{
+		`.id where .type == "click"`,
+		`.user.name`,
+		`.id where .type ==`,
+		`:tree`,
+		`:complete .user.`,
+		`.id where .type == "click"`,
+		`:history`,
+		`:quit`,
+	}, "\n") + "\n"
+	if string(data) != exp {
+		t.Errorf("expected the history:\n%s\ngot:\n%s", exp, data)
+	}
+
+	// the history is kept between sessions
+	_, stdout, _ = runTest(t, "!2\n", "repl", "-sample", sample, "-history", history)
+	if !strings.Contains(stdout, "[2] {\"user\":{\"name\":\"Jeremy\"}}") {
+		t.Errorf("expected the second line of the history to be executed:\n%s", stdout)
+	}
+}
+
+func TestREPLQueries(t *testing.T) {
+	sample := writeSample(t, t.TempDir())
+
+	testCases := []struct {
+		query string
+		exp   string
+	}{
+		{
+			`count() group by .type`,
+			"{\"count()\":1,\"type\":\"click\"}\n{\"count()\":1,\"type\":\"view\"}\n{\"count()\":1,\"type\":null}\n3 groups\n",
+		},
+		{
+			`.id order by .id desc limit 2`,
+			"{\"id\":3}\n{\"id\":2}\n2 results\n",
+		},
+		{
+			`distinct on (.users) .id`,
+			"[1] {\"id\":1}\n[3] {\"id\":3}\n2/3 samples matched\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		code, stdout, stderr := runTest(t, tc.query+"\n", "repl", "-sample", sample, "-history", "")
+		if code != exitSelected {
+			t.Fatalf("%s: expected exit status %d, got %d (stderr: %q)", tc.query, exitSelected, code, stderr)
+		}
+
+		if !strings.Contains(stdout, "> "+tc.exp) {
+			t.Errorf("%s: expected %q in the output:\n%s", tc.query, tc.exp, stdout)
+		}
+	}
+}
+
+func TestREPLErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"repl"},
+		{"repl", "-sample", filepath.Join(t.TempDir(), "missing.json")},
+		{"repl", "-sample", writeSample(t, t.TempDir()), "foobar"},
+	} {
+		if code, _, _ := runTest(t, "", args...); code != exitError {
+			t.Errorf("%v: expected exit status %d, got %d", args, exitError, code)
+		}
+	}
+}
+
+func TestCompletePath(t *testing.T) {
+	paths := []string{".a", ".a.b", ".a.b-c", ".a.b.c", ".a.bc", ".a.b[0]", ".ab", ".b"}
+
+	testCases := []struct {
+		word string
+		exp  []string
+	}{
+		{"", []string{".a", ".ab", ".b"}},
+		{".a", []string{".a.b", ".a.b-c", ".a.bc", ".ab"}},
+		{".a.b", []string{".a.b-c", ".a.b.c", ".a.bc", ".a.b[0]"}},
+		{".c", nil},
+		{"foo", nil},
+	}
+
+	for _, tc := range testCases {
+		if res := completePath(paths, tc.word); !reflect.DeepEqual(tc.exp, res) {
+			t.Errorf("%q: expected %v, got %v", tc.word, tc.exp, res)
+		}
+	}
+}
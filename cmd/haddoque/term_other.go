@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// makeRaw isn't supported on this system, the input is read line by line.
+func makeRaw(fd uintptr) (func() error, error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
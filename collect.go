@@ -0,0 +1,293 @@
+package haddoque
+
+import (
+	"container/heap"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ordered is a result along with the values of the ORDER BY keys.
+type ordered struct {
+	seq    int // order of arrival, which breaks ties
+	keys   []interface{}
+	result interface{}
+}
+
+// less reports whether a comes before b in the order of the clause.
+func (n *orderNode) less(a, b *ordered) bool {
+	for i := range n.keys {
+		c := compareOrder(a.keys[i], b.keys[i])
+		if n.desc[i] {
+			c = -c
+		}
+
+		if c != 0 {
+			return c < 0
+		}
+	}
+
+	return a.seq < b.seq
+}
+
+// orderRank returns the rank of the type of the value in the ORDER BY ordering:
+// null, then booleans, numbers, strings, arrays and objects.
+func orderRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	case map[string]interface{}:
+		return 5
+	default:
+		return 2
+	}
+}
+
+// compareOrder compares two values of any type for ORDER BY, returning -1, 0 or +1.
+//
+// Values of different types are ordered by orderRank. Arrays, objects and numbers which
+// can't be compared, like NaN, are considered equal.
+func compareOrder(l, r interface{}) int {
+	lr, rr := orderRank(l), orderRank(r)
+	switch {
+	case lr < rr:
+		return -1
+	case lr > rr:
+		return 1
+	}
+
+	switch lv := l.(type) {
+	case bool:
+		rv := r.(bool)
+		switch {
+		case lv == rv:
+			return 0
+		case rv:
+			return -1
+		default:
+			return 1
+		}
+	case string:
+		return strings.Compare(lv, r.(string))
+	case nil, []interface{}, map[string]interface{}:
+		return 0
+	default:
+		c, _ := compareNumbers(l, r)
+		return c
+	}
+}
+
+// sortOrdered sorts the items according to the ORDER BY clause, if any,
+// and returns the results of the items selected by the LIMIT and OFFSET clauses.
+func (q *Query) sortOrdered(items []*ordered) []interface{} {
+	if q.order != nil {
+		sort.Slice(items, func(i, j int) bool {
+			return q.order.less(items[i], items[j])
+		})
+	}
+
+	start := q.offset
+	if start > len(items) {
+		start = len(items)
+	}
+
+	end := len(items)
+	if q.limit >= 0 && start+q.limit < end {
+		end = start + q.limit
+	}
+
+	res := make([]interface{}, 0, end-start)
+	for _, item := range items[start:end] {
+		res = append(res, item.result)
+	}
+
+	return res
+}
+
+// topHeap keeps the first items in the order of the clause, with the last of them at the top.
+type topHeap struct {
+	order *orderNode
+	items []*ordered
+}
+
+func (h *topHeap) Len() int           { return len(h.items) }
+func (h *topHeap) Less(i, j int) bool { return h.order.less(h.items[j], h.items[i]) }
+func (h *topHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*ordered))
+}
+
+func (h *topHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+
+	return last
+}
+
+// Collector executes a query on objects added one by one, and gathers the results of the matching ones
+// according to the ORDER BY, LIMIT and OFFSET clauses of the query:
+//
+//	.id, .timestamp where .type == "click" order by .timestamp desc limit 20 offset 40
+//
+// With a LIMIT clause, only the results which can still be returned are kept: OFFSET + LIMIT results
+// at most, in a heap when the query has an ORDER BY clause. Otherwise all the results are kept.
+// With a DISTINCT clause, the duplicates are discarded before the other clauses apply, and all the keys are kept
+// unless bounded with WithDistinctBounds.
+//
+// A Collector is not safe for concurrent use.
+type Collector struct {
+	q     *Query
+	seq   int // number of matching objects
+	items topHeap
+	dedup *Deduplicator // nil without DISTINCT clause
+
+	onError func(err *RecordError) error
+}
+
+// NewCollector returns a Collector executing the query.
+//
+// The options are the ones of Filter: WithDistinctBounds bounds the keys kept for a DISTINCT query,
+// and WithRecordErrorHandler reports the invalid records read by AddStream.
+//
+// ErrAggregateQuery is returned for a query with aggregates, the Aggregator applies the clauses to the groups instead.
+func NewCollector(q *Query, opts ...FilterOption) (*Collector, error) {
+	if q.aggregate {
+		return nil, ErrAggregateQuery
+	}
+
+	var o filterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Collector{
+		q:       q,
+		items:   topHeap{order: q.order},
+		onError: o.onError,
+	}
+	if q.distinct != nil {
+		c.dedup, _ = NewDeduplicator(q, o.capacity, o.window)
+	}
+
+	return c, nil
+}
+
+// Reset discards all the results.
+func (c *Collector) Reset() {
+	c.seq = 0
+	c.items.items = nil
+	if c.dedup != nil {
+		c.dedup.Reset()
+	}
+}
+
+// Add executes the query on an object, and keeps its result if it matches.
+//
+// The object can be anything accepted by Query.Exec, and the errors are the same.
+func (c *Collector) Add(obj interface{}) error {
+	_, _, err := c.add(obj)
+	return err
+}
+
+// AddBytes executes the query on a JSON document like Add. Only the parts of the document used by the query
+// are decoded, see Query.ExecBytes.
+//
+// An *InvalidJSONError is returned if the data is not valid JSON.
+func (c *Collector) AddBytes(data []byte) error {
+	_, _, err := c.addBytes(data)
+	return err
+}
+
+// AddStream executes the query on each JSON value read from r like AddBytes. The stream is read like Filter does,
+// the invalid records being returned as RecordErrors once the input is exhausted unless reported
+// with WithRecordErrorHandler, but the reading stops early once Done is true.
+func (c *Collector) AddStream(r io.Reader) error {
+	return streamReader{exec: c.addBytes, done: c.Done, onError: c.onError}.read(r, c.q)
+}
+
+// Done reports whether the results are complete: the query has a LIMIT clause but no ORDER BY clause,
+// and enough objects matched already.
+func (c *Collector) Done() bool {
+	return c.q.order == nil && c.q.limit >= 0 && c.seq >= c.q.offset+c.q.limit
+}
+
+func (c *Collector) addBytes(data []byte) (interface{}, bool, error) {
+	obj, err := extractJSON(data, c.q.paths)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return c.add(obj)
+}
+
+func (c *Collector) add(obj interface{}) (interface{}, bool, error) {
+	on := newObjNode(obj)
+	if on == nil {
+		return nil, false, ErrInvalidObject
+	}
+
+	res, matched, err := c.q.execNode(on)
+	if err != nil || !matched {
+		return res, matched, err
+	}
+
+	if c.dedup != nil && c.dedup.duplicate(on, res) {
+		return res, false, nil
+	}
+
+	item := &ordered{seq: c.seq, result: res}
+	c.seq++
+
+	if c.q.order == nil {
+		// the results before the offset and after the limit are never returned
+		if item.seq >= c.q.offset && (c.q.limit < 0 || item.seq < c.q.offset+c.q.limit) {
+			c.items.items = append(c.items.items, item)
+		}
+
+		return res, matched, nil
+	}
+
+	item.keys = make([]interface{}, len(c.q.order.keys))
+	for i, k := range c.q.order.keys {
+		// an absent key is ordered like null
+		item.keys[i], _ = getValue(k, on)
+	}
+
+	switch size := c.q.offset + c.q.limit; {
+	case c.q.limit < 0:
+		c.items.items = append(c.items.items, item)
+	case c.items.Len() < size:
+		heap.Push(&c.items, item)
+	case size > 0 && c.q.order.less(item, c.items.items[0]):
+		// the new result replaces the last of the first results
+		c.items.items[0] = item
+		heap.Fix(&c.items, 0)
+	}
+
+	return res, matched, nil
+}
+
+// Results returns the results of the matching objects, ordered and limited as specified by the query.
+// Without ORDER BY clause, the results are in the order in which the objects were added.
+func (c *Collector) Results() []interface{} {
+	if c.q.order == nil {
+		res := make([]interface{}, len(c.items.items))
+		for i, item := range c.items.items {
+			res[i] = item.result
+		}
+
+		return res
+	}
+
+	// the heap must be kept intact for the next objects
+	items := append([]*ordered(nil), c.items.items...)
+
+	return c.q.sortOrdered(items)
+}
@@ -0,0 +1,184 @@
+package haddoque_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vrischmann/haddoque"
+)
+
+var collectTests = []resultsTest{
+	{`.id order by .ts`, `[{"id":4},{"id":2},{"id":7},{"id":3},{"id":5},{"id":1},{"id":6}]`},
+	{`.id order by .ts desc`, `[{"id":6},{"id":1},{"id":5},{"id":3},{"id":2},{"id":7},{"id":4}]`},
+	{`.id order by .type desc, .ts limit 3`, `[{"id":2},{"id":5},{"id":4}]`},
+	{`.id order by .ts desc limit 2 offset 1`, `[{"id":1},{"id":5}]`},
+	{`.id where .type == "click" order by .id * -1 limit 2`, `[{"id":7},{"id":6}]`},
+	{`.id order by len(.type), .id desc limit 1`, `[{"id":5}]`},
+	{`.id order by .ts limit 0`, `[]`},
+	{`.id order by .ts offset 10`, `[]`},
+	{`.id limit 2`, `[{"id":1},{"id":2}]`},
+	{`.id limit 2 offset 5`, `[{"id":6},{"id":7}]`},
+	{`.id offset 5`, `[{"id":6},{"id":7}]`},
+	{`.id where .type == "view" limit 5 offset 1`, `[{"id":5}]`},
+	{`.id as out.id, .ts * 2 as out.ts order by out.ts desc limit 2`, `[{"out":{"id":1,"ts":60}},{"out":{"id":5,"ts":41}}]`},
+}
+
+func TestCollector(t *testing.T) {
+	input := readInput(t, "collect.ndjson")
+	for _, test := range collectTests {
+		equals(t, test.expected, queryResults(t, test.query, input))
+	}
+}
+
+func TestCollectorHeap(t *testing.T) {
+	// the results are the same whatever the order in which the objects are added
+	c, err := haddoque.NewCollector(haddoque.MustCompile(`.n order by .n desc limit 5 offset 3`))
+	ok(t, err)
+
+	for i := 0; i < 100; i++ {
+		ok(t, c.Add(map[string]interface{}{"n": (i * 37) % 100}))
+	}
+
+	equals(t, `[{"n":96},{"n":95},{"n":94},{"n":93},{"n":92}]`, encodeJSON(t, c.Results()))
+
+	// Results doesn't change the state of the collector
+	ok(t, c.Add(map[string]interface{}{"n": 1000}))
+	equals(t, `[{"n":97},{"n":96},{"n":95},{"n":94},{"n":93}]`, encodeJSON(t, c.Results()))
+
+	c.Reset()
+	equals(t, []interface{}{}, c.Results())
+}
+
+func TestCollectorStream(t *testing.T) {
+	c, err := haddoque.NewCollector(haddoque.MustCompile(`.id order by .ts desc limit 2`))
+	ok(t, err)
+
+	input := readInput(t, "collect.ndjson")
+	err = c.AddStream(strings.NewReader(input + `{"id": ]` + "\n"))
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+	equals(t, 1, len(errs.Errors))
+	equals(t, 8, errs.Errors[0].Line)
+
+	equals(t, `[{"id":6},{"id":1}]`, encodeJSON(t, c.Results()))
+
+	// without ORDER BY clause, the reading stops once the limit is reached
+	c, err = haddoque.NewCollector(haddoque.MustCompile(`.id limit 2 offset 1`))
+	ok(t, err)
+
+	ok(t, c.AddStream(strings.NewReader(strings.Split(input, "\n")[0]+"\n{\"id\": 2}\n{\"id\": 3}\n{\"id\": ]\n")))
+	equals(t, true, c.Done())
+
+	equals(t, `[{"id":2},{"id":3}]`, encodeJSON(t, c.Results()))
+}
+
+func TestCollectorOptions(t *testing.T) {
+	c, err := haddoque.NewCollector(haddoque.MustCompile(`distinct on (.id) .n limit 10`), haddoque.WithDistinctBounds(1, 0))
+	ok(t, err)
+
+	for i, id := range []int{1, 2, 1, 1, 2} {
+		ok(t, c.Add(map[string]interface{}{"id": id, "n": i}))
+	}
+	equals(t, `[{"n":0},{"n":1},{"n":2},{"n":4}]`, encodeJSON(t, c.Results()))
+
+	var lines []int
+	c, err = haddoque.NewCollector(haddoque.MustCompile(`.id order by .id`), haddoque.WithRecordErrorHandler(func(err *haddoque.RecordError) error {
+		lines = append(lines, err.Line)
+		return nil
+	}))
+	ok(t, err)
+
+	ok(t, c.AddStream(strings.NewReader("{\"id\": 2}\n{\"id\": ]\n{\"id\": 1}\n{\"name\": \"no id\"}\n")))
+	equals(t, []int{2, 4}, lines)
+	equals(t, `[{"id":1},{"id":2}]`, encodeJSON(t, c.Results()))
+}
+
+func TestCollectorBytes(t *testing.T) {
+	c, err := haddoque.NewCollector(haddoque.MustCompile(`.id order by .ts limit 1`))
+	ok(t, err)
+
+	ok(t, c.AddBytes([]byte(`{"id": 1, "ts": 2, "payload": {"big": [1, 2, 3]}}`)))
+	ok(t, c.AddBytes([]byte(`{"id": 2, "ts": 1}`)))
+
+	err = c.AddBytes([]byte(`{"id": 3, "ts": `))
+	assert(t, errors.Is(err, haddoque.ErrInvalidObject), "expected an invalid JSON error, got %v", err)
+
+	equals(t, []interface{}{map[string]interface{}{"id": json.Number("2")}}, c.Results())
+}
+
+func TestCollectionQuery(t *testing.T) {
+	equals(t, true, haddoque.MustCompile(`.id order by .ts`).IsCollection())
+	equals(t, true, haddoque.MustCompile(`.id limit 10`).IsCollection())
+	equals(t, true, haddoque.MustCompile(`.id offset 10`).IsCollection())
+	equals(t, false, haddoque.MustCompile(`.id offset 0`).IsCollection())
+	equals(t, false, haddoque.MustCompile(`.id where .ts > 1`).IsCollection())
+
+	_, err := haddoque.NewCollector(haddoque.MustCompile(`count() group by .type order by count()`))
+	equals(t, haddoque.ErrAggregateQuery, err)
+
+	input := readInput(t, "collect.ndjson")
+
+	var buf bytes.Buffer
+	err = haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id where .type == "click" order by .ts desc limit 2`))
+	ok(t, err)
+	equals(t, "{\"id\":6}\n{\"id\":1}\n", buf.String())
+
+	// the Iterator ignores the clauses
+	it := haddoque.NewIterator(strings.NewReader(input), haddoque.MustCompile(`.id order by .ts limit 1`))
+	n := 0
+	for it.Next() {
+		n++
+	}
+	ok(t, it.Err())
+	equals(t, 7, n)
+}
+
+var aggregateOrderTests = []resultsTest{
+	{
+		`count() group by .device.platform order by count() desc, .device.platform limit 2`,
+		`[{"count()":3,"device":{"platform":"android"}},{"count()":1,"device":{"platform":null}}]`,
+	},
+	{
+		`.device.platform group by .device.platform order by max(.latency) desc`,
+		`[{"device":{"platform":"ios"}},{"device":{"platform":"android"}},{"device":{"platform":null}},{"device":{"platform":"web"}}]`,
+	},
+	{
+		`count() group by .status order by .status offset 1`,
+		`[{"count()":5,"status":500}]`,
+	},
+	{
+		`count() limit 0`,
+		`[]`,
+	},
+}
+
+func TestAggregatorOrder(t *testing.T) {
+	input := readInput(t, "aggregate.ndjson")
+	for _, test := range aggregateOrderTests {
+		equals(t, test.expected, queryResults(t, test.query, input))
+	}
+}
+
+var collectCompileErrorTests = []compileErrorTest{
+	{`.id order .ts`, `unexpected ".ts"`},
+	{`.id order by`, `unexpected end of query`},
+	{`.id order by (.a == 1)`, `order by key must be a value`},
+	{`.id order by .a == 1`, `unexpected "=="`},
+	{`.id order by .a order by .b`, `duplicate order clause`},
+	{`.id order by count()`, `aggregate count can only be used in an aggregate query`},
+	{`count() group by .type order by .id`, `order by key must be an aggregate or a group by key`},
+	{`.id limit`, `unexpected end of query`},
+	{`.id limit "10"`, `unexpected "\"10\""`},
+	{`.id limit 1.5`, `bad limit "1.5"`},
+	{`.id limit -1`, `unexpected "-"`},
+	{`.id limit 1 limit 2`, `duplicate limit clause`},
+	{`.id offset 1 offset 2`, `duplicate offset clause`},
+}
+
+func TestOrderCompileErrors(t *testing.T) {
+	testCompileErrors(t, collectCompileErrorTests)
+}
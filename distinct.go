@@ -0,0 +1,142 @@
+package haddoque
+
+import (
+	"container/list"
+	"io"
+	"time"
+)
+
+// Deduplicator executes a query with a DISTINCT clause on objects one by one, like
+//
+//	distinct on (.id, .type) . where .type == "click"
+//
+// Only the first matching object with given keys matches, the next ones are duplicates.
+// With DISTINCT alone, the key is the result of the query.
+//
+// The keys already seen are kept in memory. A capacity and a time window bound them, so that
+// a Deduplicator can run on an unbounded stream: an object whose key was forgotten matches again.
+//
+// A Deduplicator is not safe for concurrent use.
+type Deduplicator struct {
+	q        *Query
+	capacity int
+	window   time.Duration
+	now      func() time.Time
+	seen     map[string]*list.Element
+	lru      *list.List // of *seenKey, the most recently seen first
+}
+
+// seenKey is a key kept by a Deduplicator.
+type seenKey struct {
+	key  string
+	last time.Time
+}
+
+// NewDeduplicator returns a Deduplicator executing the query, which must have a DISTINCT clause.
+//
+// If capacity is positive, at most capacity keys are kept, the least recently seen being forgotten first.
+// If window is positive, a key is forgotten once it wasn't seen for that long.
+// Otherwise all the keys are kept.
+//
+// ErrNotDistinctQuery is returned for a query without DISTINCT clause.
+func NewDeduplicator(q *Query, capacity int, window time.Duration) (*Deduplicator, error) {
+	if q.distinct == nil {
+		return nil, ErrNotDistinctQuery
+	}
+
+	d := &Deduplicator{
+		q:        q,
+		capacity: capacity,
+		window:   window,
+		now:      time.Now,
+	}
+	d.Reset()
+
+	return d, nil
+}
+
+// Reset forgets all the keys.
+func (d *Deduplicator) Reset() {
+	d.seen = make(map[string]*list.Element)
+	d.lru = list.New()
+}
+
+// Len returns the number of keys kept.
+func (d *Deduplicator) Len() int {
+	return d.lru.Len()
+}
+
+// Exec executes the query on an object like Query.Exec, also reporting whether it matched
+// and wasn't a duplicate.
+func (d *Deduplicator) Exec(obj interface{}) (interface{}, bool, error) {
+	on := newObjNode(obj)
+	if on == nil {
+		return nil, false, ErrInvalidObject
+	}
+
+	res, matched, err := d.q.execNode(on)
+	if err != nil || !matched {
+		return res, matched, err
+	}
+
+	return res, !d.duplicate(on, res), nil
+}
+
+// ExecBytes executes the query on a JSON document like Exec. Only the parts of the document used by the query
+// are decoded, see Query.ExecBytes.
+//
+// An *InvalidJSONError is returned if the data is not valid JSON.
+func (d *Deduplicator) ExecBytes(data []byte) (interface{}, bool, error) {
+	obj, err := extractJSON(data, d.q.paths)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return d.Exec(obj)
+}
+
+// Iterate returns an Iterator executing the query on the JSON values read from r, on which duplicates don't match.
+func (d *Deduplicator) Iterate(r io.Reader) *Iterator {
+	return newIterator(r, d.q, d.ExecBytes)
+}
+
+// duplicate reports whether the key of a matching object was already seen, and records it.
+func (d *Deduplicator) duplicate(on *objNode, res interface{}) bool {
+	keys := []interface{}{res}
+	if d.q.distinct.keys != nil {
+		keys = make([]interface{}, len(d.q.distinct.keys))
+		for i, k := range d.q.distinct.keys {
+			// an absent key is the same as null
+			keys[i], _ = getValue(k, on)
+		}
+	}
+	key := groupKey(keys)
+
+	var now time.Time
+	if d.window > 0 {
+		now = d.now()
+
+		// the least recently seen keys are at the back
+		for el := d.lru.Back(); el != nil && now.Sub(el.Value.(*seenKey).last) >= d.window; el = d.lru.Back() {
+			d.forget(el)
+		}
+	}
+
+	if el, ok := d.seen[key]; ok {
+		el.Value.(*seenKey).last = now
+		d.lru.MoveToFront(el)
+		return true
+	}
+
+	d.seen[key] = d.lru.PushFront(&seenKey{key: key, last: now})
+	if d.capacity > 0 && d.lru.Len() > d.capacity {
+		d.forget(d.lru.Back())
+	}
+
+	return false
+}
+
+func (d *Deduplicator) forget(el *list.Element) {
+	d.lru.Remove(el)
+	delete(d.seen, el.Value.(*seenKey).key)
+}
@@ -0,0 +1,178 @@
+package haddoque_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vrischmann/haddoque"
+)
+
+var distinctTests = []resultsTest{
+	{`distinct on (.id, .type) .n`, `[{"n":1},{"n":2},{"n":4},{"n":6}]`},
+	{`distinct on (.id) .n where .type == "view"`, `[{"n":4},{"n":6}]`},
+	{`distinct on (.id * 2) .n`, `[{"n":1},{"n":2},{"n":6}]`},
+	{`distinct on (upper(.type)) .n`, `[{"n":1},{"n":4}]`},
+	{`distinct .type`, `[{"type":"click"},{"type":"view"}]`},
+	{`distinct .id?, .type`, `[{"id":1,"type":"click"},{"id":2,"type":"click"},{"id":1,"type":"view"},{"type":"view"},{"id":null,"type":"view"}]`},
+	{`distinct on (.id) .n order by .n desc limit 2`, `[{"n":6},{"n":2}]`},
+}
+
+func TestDeduplicator(t *testing.T) {
+	input := readInput(t, "distinct.ndjson")
+	for _, test := range distinctTests {
+		equals(t, test.expected, queryResults(t, test.query, input))
+	}
+}
+
+func TestDeduplicatorIterate(t *testing.T) {
+	d, err := haddoque.NewDeduplicator(haddoque.MustCompile(`distinct on (.type) .n`), 0, 0)
+	ok(t, err)
+
+	var lines []int
+	it := d.Iterate(strings.NewReader(readInput(t, "distinct.ndjson")))
+	for it.Next() {
+		rec := it.Record()
+		ok(t, rec.Err)
+
+		if rec.Matched {
+			lines = append(lines, rec.Line)
+		}
+	}
+	ok(t, it.Err())
+	equals(t, []int{1, 4}, lines)
+}
+
+func TestDeduplicatorCapacity(t *testing.T) {
+	d, err := haddoque.NewDeduplicator(haddoque.MustCompile(`distinct on (.id) .n`), 2, 0)
+	ok(t, err)
+
+	input := `{"id": 1, "n": 1}
+{"id": 2, "n": 2}
+{"id": 1, "n": 3}
+{"id": 3, "n": 4}
+{"id": 2, "n": 5}
+{"id": 1, "n": 6}
+`
+	// 2 is forgotten when 3 is seen, since 1 was seen more recently, then 1 when 2 is seen again
+	equals(t, `[{"n":1},{"n":2},{"n":4},{"n":5},{"n":6}]`, encodeJSON(t, execAll(t, d.Exec, input)))
+	equals(t, 2, d.Len())
+
+	d.Reset()
+	equals(t, 0, d.Len())
+	equals(t, `[{"n":1},{"n":2},{"n":4},{"n":5},{"n":6}]`, encodeJSON(t, execAll(t, d.Exec, input)))
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d, err := haddoque.NewDeduplicator(haddoque.MustCompile(`distinct on (.id) .n`), 0, time.Minute)
+	ok(t, err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	haddoque.SetDeduplicatorClock(d, func() time.Time { return now })
+
+	exec := func(id, n int) bool {
+		_, matched, err := d.Exec(map[string]interface{}{"id": id, "n": n})
+		ok(t, err)
+		return matched
+	}
+
+	equals(t, true, exec(1, 1))
+	now = now.Add(30 * time.Second)
+	equals(t, true, exec(2, 2))
+	now = now.Add(20 * time.Second)
+	equals(t, false, exec(1, 3))
+
+	// 2 was last seen 65s ago and is forgotten, 1 only 45s ago
+	now = now.Add(45 * time.Second)
+	equals(t, true, exec(3, 4))
+	equals(t, 2, d.Len())
+	equals(t, true, exec(2, 5))
+	equals(t, false, exec(1, 6))
+
+	now = now.Add(time.Hour)
+	equals(t, true, exec(1, 7))
+	equals(t, 1, d.Len())
+}
+
+func TestDeduplicatorErrors(t *testing.T) {
+	_, err := haddoque.NewDeduplicator(haddoque.MustCompile(`.id`), 0, 0)
+	equals(t, haddoque.ErrNotDistinctQuery, err)
+
+	d, err := haddoque.NewDeduplicator(haddoque.MustCompile(`distinct .id`), 0, 0)
+	ok(t, err)
+
+	_, _, err = d.Exec(map[string]interface{}{"name": "foo"})
+	assert(t, errors.Is(err, haddoque.ErrNonExistingFields), "expected a missing field error, got %v", err)
+
+	_, _, err = d.Exec(10)
+	equals(t, haddoque.ErrInvalidObject, err)
+
+	res, matched, err := d.ExecBytes([]byte(`{"id": 1, "name": "foo"}`))
+	ok(t, err)
+	equals(t, true, matched)
+	equals(t, map[string]interface{}{"id": json.Number("1")}, res)
+
+	_, matched, err = d.ExecBytes([]byte(`{"name": "bar", "id": 1.0}`))
+	ok(t, err)
+	equals(t, false, matched)
+}
+
+func TestDistinctQuery(t *testing.T) {
+	equals(t, true, haddoque.MustCompile(`distinct .id`).IsDistinct())
+	equals(t, false, haddoque.MustCompile(`.id`).IsDistinct())
+
+	// Exec ignores the clause
+	q := haddoque.MustCompile(`distinct .id`)
+	for i := 0; i < 2; i++ {
+		res, err := q.Exec(map[string]interface{}{"id": 1})
+		ok(t, err)
+		equals(t, map[string]interface{}{"id": 1}, res)
+	}
+
+	input := readInput(t, "distinct.ndjson")
+
+	var buf bytes.Buffer
+	ok(t, haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`distinct on (.type) .n`)))
+	equals(t, "{\"n\":1}\n{\"n\":4}\n", buf.String())
+
+	// the duplicates are discarded before the other clauses
+	buf.Reset()
+	ok(t, haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`distinct on (.id) .n order by .n desc limit 2`)))
+	equals(t, "{\"n\":6}\n{\"n\":2}\n", buf.String())
+
+	// the keys can be bounded like with a Deduplicator
+	input = `{"id": 1, "n": 1}
+{"id": 2, "n": 2}
+{"id": 1, "n": 3}
+{"id": 3, "n": 4}
+{"id": 2, "n": 5}
+{"id": 1, "n": 6}
+`
+	for query, exp := range map[string]string{
+		`distinct on (.id) .n`:                  "{\"n\":1}\n{\"n\":2}\n{\"n\":4}\n{\"n\":5}\n{\"n\":6}\n",
+		`distinct on (.id) .n order by .n desc`: "{\"n\":6}\n{\"n\":5}\n{\"n\":4}\n{\"n\":2}\n{\"n\":1}\n",
+		`distinct on (.id) .n limit 2 offset 2`: "{\"n\":4}\n{\"n\":5}\n",
+	} {
+		buf.Reset()
+		ok(t, haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(query), haddoque.WithDistinctBounds(2, 0)))
+		equals(t, exp, buf.String())
+	}
+}
+
+var distinctCompileErrorTests = []compileErrorTest{
+	{`distinct count() group by .type`, `distinct can't be used in an aggregate query`},
+	{`distinct on (.type) .id group by .id`, `distinct can't be used in an aggregate query`},
+	{`distinct on .id .n`, `unexpected ".id"`},
+	{`distinct on () .n`, `unexpected ")"`},
+	{`distinct on (.id where .n == 1`, `unexpected "where"`},
+	{`distinct on ((.a == 1)) .n`, `distinct on key must be a value`},
+	{`distinct on (count()) .n`, `aggregate count can only be used as a selected field`},
+	{`.n distinct`, `unexpected "distinct"`},
+}
+
+func TestDistinctCompileErrors(t *testing.T) {
+	testCompileErrors(t, distinctCompileErrorTests)
+}
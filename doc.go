@@ -9,13 +9,50 @@ The syntax is loosely based on SQL and Go's text/template.
 
 A query is composed of:
 
-  - a mandatory field selector
+  - a mandatory field selector, optionally preceded by "distinct"
   - optional filter conditions
+  - optional "group by", "order by", "limit" and "offset" clauses
 
 For example:
 
     .name where (.id == 1)
 
+Executing a query
+
+A query is first compiled, then executed against as many objects as needed:
+
+    q, err := haddoque.Compile(`.name where (.id == 1)`)
+    if err != nil {
+        // the query is malformed, err is a *SyntaxError
+    }
+
+    res, err := q.Exec(obj)
+
+The object can be a map[string]interface{} decoded by encoding/json, or any Go value encoding to a JSON object
+or array: a struct, a map, a slice or a pointer to them. Go values are queried exactly like their JSON encoding,
+so struct fields are named after their json tags, and omitted if they're tagged "-" or empty with omitempty.
+Like with encoding/json, a value containing itself can't be queried, ErrInvalidObject is returned instead.
+
+ExecBytes executes the query directly on a JSON document. Only the parts of the document used by the query
+are decoded, the rest is skipped, which is much faster than decoding the whole document first:
+
+    res, err := q.ExecBytes(msg.Value)
+
+Filter executes a query on a stream of JSON values, newline-delimited or not, or on the elements of a JSON array,
+and writes the results of the matching values as newline-delimited JSON:
+
+    err := haddoque.Filter(os.Stdin, os.Stdout, q)
+
+Invalid values don't stop the stream, they're reported with their line number once it's exhausted,
+or as they're read with the WithRecordErrorHandler option.
+An Iterator gives access to each value of the stream along with its result.
+
+A compiled Query is immutable and can be shared by multiple goroutines.
+Exec is a shortcut that compiles the query on every call.
+
+When a query is malformed, the returned *SyntaxError gives the line and column of the problem,
+and its Format method renders the query with a caret under the offending token.
+
 Field selector
 
 A field selector is a representation of the path to get to the field you want in the map.
@@ -24,6 +61,29 @@ In other words, ".data.id" means getting the field map["data"]["id"].
 
 There's a special case with the "." field selector: it returns the entire source map
 
+Arrays can be traversed with selectors:
+
+    .items[0].sku    the field "sku" of the first element of "items"
+    .items[-1]       the last element
+    .items[1:3]      the elements 1 and 2; both bounds are optional and can be negative
+    .items[*].sku    the field "sku" of every element
+
+Selected fields are returned at the same location they have in the source map,
+so ".items[*].sku" returns the array "items" with only the field "sku" in each element.
+
+Missing fields
+
+By default, executing a query fails with a *MissingFieldsError if a selected field does not exist in the map.
+A field can be made optional with a trailing "?", in which case it's simply omitted from the result:
+
+    .id, .device.os?
+
+The behaviour for all fields can be changed when compiling the query:
+
+    q, err := haddoque.Compile(`.id, .device.os`, haddoque.WithMissingFields(haddoque.MissingAsNull))
+
+With MissingAsNull missing fields are set to null in the result, with MissingOmit they're omitted.
+
 Condition
 
 A condition is a binary expression, which has to evaluate to true for the query to return something.
@@ -36,13 +96,254 @@ Example:
 
 Those are all valid conditions.
 
-To be valid in a query though you need to enclose all expressions into parentheses like we did above.
-This is a limitation of the engine that may or may not be removed in the future.
+Both sides of an operator can be any expression: a field, a literal, a function call or an arithmetic expression.
+
+    .billing.country == .shipping.country
+    "admin" in .roles
+    10 < .count
+
+Equality follows the JSON semantics: values of different types are never equal, except numbers,
+arrays are equal if they have equal elements in the same order, and objects if they have the same keys
+with equal values. Booleans, arrays and objects can only be compared for equality, with literals written like in JSON:
+
+    .active == true
+    .tags == ["a", "b"]
+    .geo == {"lat": 1, "lon": 2}
+
+The elements of lists and objects can also be fields, function calls or arithmetic expressions;
+an element which isn't present is left out:
+
+    .country in [.billing.country, .shipping.country]
+
+"in" is true if the left value is an element of the array on the right, which can be a field or a list literal.
+"contains" is true if the array on the left has the right value as an element, or all of its elements
+if the right value is an array.
+
+Numbers
+
+The values of the map can be of any Go numeric type, or json.Number when decoded with json.Decoder.UseNumber.
+Numbers of different types are compared exactly: integers are never rounded to a float,
+so identifiers beyond 2^53 decoded as json.Number are correctly told apart. The same goes for integer
+literals of the query, which can be beyond the range of an int64.
+
+Arithmetic
+
+Numbers can be computed with +, -, *, / and %, and strings concatenated with +:
+
+    . where .price * .qty > 1000
+    . where .end - .start >= 60
+    . where .first + " " + .last == "Vincent Rischmann"
+
+*, / and % bind tighter than + and -, which bind tighter than comparisons.
+Like in Go, dividing two integers truncates the result toward zero. An integer operation which overflows
+an int64 is done on float64 instead, as is any operation mixing an integer and a float.
+Dividing by zero, or using an absent, null or non-numeric operand, gives nothing, which makes any
+condition using the result false.
+
+Pattern matching
+
+Strings can be matched against a regular expression with "matches", using the RE2 syntax of the regexp package,
+or against a glob with "like", where * matches any sequence of characters and ? a single character:
+
+    . where .path matches "^/api/v[0-9]+/"
+    . where .host like "*.example.com"
+
+A regular expression matches anywhere in the string unless anchored, whereas a glob must match the whole string.
+Patterns must be string literals; they're compiled with the query, so an invalid pattern is a syntax error.
+
+Functions
+
+Functions can be called in conditions and in the field selector, where they compute new fields:
+
+    .id, lower(.name) where starts_with(.path, "/api") and len(.items) > 2
+
+A computed field is returned at the top level of the result, with the text of the call as its key.
+The available functions are:
+
+    lower(s), upper(s), trim(s)        change the case of s or remove its leading and trailing whitespace
+    len(v)                             number of characters of a string, elements of an array or fields of an object
+    substr(s, start[, length])         substring of s, start can be negative to count from the end
+    starts_with(s, prefix)             true if s starts with prefix
+    ends_with(s, suffix)               true if s ends with suffix
+    contains_str(s, substr)            true if substr is within s
+    split(s, sep)                      array of the substrings of s separated by sep
+    concat(s...)                       concatenation of all the arguments
+    replace(s, old, new)               s with all the occurrences of old replaced by new
+
+A call evaluates to nothing if an argument is absent or of the wrong type, which makes any condition using it false.
+
+Custom functions can be registered when compiling the query, like with text/template:
+
+    q, err := haddoque.Compile(`. where is_eu(.country)`, haddoque.WithFuncs(haddoque.FuncMap{
+        "is_eu": func(country string) bool { return euCountries[country] },
+    }))
+
+A registered function takes precedence over a builtin function or an aggregate of the same name.
+The number of arguments of a call, and the type of its literal arguments, are checked when compiling the query.
+
+Aliases
+
+Arithmetic expressions can be selected like function calls, and any selected field can be renamed with "as".
+The name of an alias is an identifier or a quoted string, followed by fields to build nested objects.
+The field selector can start with "select", like in SQL:
+
+    select .data.names.firstName as first, len(.items) as n_items, .price * .qty as total, .id as out.meta.id
+
+returns:
+
+    {"first": "Vincent", "n_items": 3, "total": 1250, "out": {"meta": {"id": 1}}}
+
+Without alias, a computed field is returned at the top level with its text as the key, like a function call.
+Missing renamed fields are handled like the other fields. Two aliases can't be the same, or a prefix of each other.
+An alias can be used as a key of "order by".
+
+Aggregations
+
+Aggregates compute a value over all the objects satisfying the conditions, instead of a result per object:
+
+    count(), avg(.latency) group by .device.platform where (.status == 500)
+
+The available aggregates are:
+
+    count()      number of objects
+    count(v)     number of objects where v is present and not null
+    count(c)     number of objects satisfying the condition c
+    sum(v)       sum of the numbers
+    avg(v)       average of the numbers, as a float
+    min(v)       smallest number or string
+    max(v)       largest number or string
+
+Absent and null values are ignored, as well as values which aren't numbers for sum and avg.
+sum, avg, min and max are null if no value was found.
+
+"group by" computes the aggregates for each distinct value of its keys, which are fields or function calls
+separated by commas. Without it, all the objects are in a single group. The only fields which can be selected
+along with aggregates are the keys, which are part of the results anyway.
+
+An aggregate query is executed with an Aggregator, which only keeps the state of each group,
+so its memory is bounded by the number of groups:
+
+    agg, err := haddoque.NewAggregator(q)
+    ...
+    for _, obj := range objects {
+        err := agg.Add(obj)
+        ...
+    }
+
+    results := agg.Results()
+
+AddBytes adds a JSON document like ExecBytes, and AddStream all the values of a stream like Filter.
+
+Each result is an object with the keys at the same location they have in the objects, and the aggregates
+at the top level with their text as the key:
+
+    {"device": {"platform": "android"}, "count()": 2, "avg(.latency)": 150}
+
+Keys and aggregates can be renamed with aliases:
+
+    .device.platform as platform, count() as n group by .device.platform
+
+Ordering and limits
+
+The results can be ordered, and only some of them returned, with the "order by", "limit" and "offset" clauses:
+
+    .id, .timestamp where .type == "click" order by .timestamp desc limit 20 offset 40
+
+"order by" sorts the results by its keys, which are fields, function calls or arithmetic expressions
+separated by commas, each one followed by "asc" (the default) or "desc". Values of different types are
+ordered null first, then booleans, numbers, strings, arrays and objects; an absent key is ordered like null.
+Results with equal keys keep the order in which the objects were added.
+"offset" skips the first results, and "limit" is the maximum number of results returned.
+
+Those clauses apply to all the results, so a query using them is executed with a Collector:
+
+    c, err := haddoque.NewCollector(q)
+    ...
+    err := c.AddStream(os.Stdin)
+    ...
+    results := c.Results()
+
+With a limit, a Collector only keeps the results which can still be returned, in a heap when the results
+are ordered, so its memory is bounded by the offset plus the limit. Filter uses a Collector for such a query,
+whereas Exec and the Iterator ignore the clauses.
+
+In an aggregate query, the clauses apply to the groups, and the keys of "order by" must be aggregates
+or keys of "group by":
+
+    count() group by .device.platform order by count() desc limit 3
+
+Duplicates
+
+A query starting with "distinct" only returns the first of the objects with the same keys, the next ones being
+duplicates. "distinct on" takes its keys between parentheses, which are fields, function calls or arithmetic
+expressions, and "distinct" alone uses the result of the query as the key:
+
+    distinct on (.id, .type) . where .type == "click"
+    distinct .user.country
+
+Keys are equal like in conditions, and an absent key is the same as null.
+A distinct query can't have aggregates.
+
+Duplicates are filtered with a Deduplicator, which remembers the keys already seen. As it would run out of memory
+on an unbounded stream, the keys can be bounded by a capacity, the least recently seen keys being forgotten first,
+and by a time window, a key being forgotten once it wasn't seen for that long:
+
+    d, err := haddoque.NewDeduplicator(q, 100000, 10*time.Minute)
+    ...
+    it := d.Iterate(os.Stdin)
+    for it.Next() {
+        rec := it.Record() // rec.Matched is false for a duplicate
+        ...
+    }
+
+An object whose key was forgotten is no longer a duplicate. Filter and the Collector remember all the keys,
+unless given the WithDistinctBounds option, whereas Exec and NewIterator ignore the clause.
+The duplicates are discarded before "order by", "limit" and "offset" apply.
+
+Null values
+
+JSON null values are written null. A field which is absent from the map is considered null,
+use exists to tell apart an absent field from a field holding null:
+
+    . where .deletedAt is null
+    . where .deletedAt is not null
+    . where .deletedAt == null and exists(.deletedAt)
+
+Apart from "is null", null is only equal to itself and comparisons with an absent field are always false.
+
+Negation
+
+A condition can be negated with "not" or "!":
+
+    . where not (.type in ["a", "b"])
+    . where !(.deleted == true)
+
+Quantifiers
+
+Conditions on the elements of an array are expressed with the quantifiers any, all and none.
+The condition is evaluated with each element as the root object:
+
+    . where any(.items, .price > 100)
+    . where all(.items, .qty >= 1)
+    . where none(.tags, . == "deleted")
+
+all and none are true for an empty array; all quantifiers are false if the field is not an array.
 
 Combining conditions
 
 You can combine any number of conditions, like so:
 
-    . where ( (.id == 1) and (.name == "foobar) ) or (.mobile.type == "iPhone")
+    . where .id == 1 and .name == "foobar" or .mobile.type == "iPhone"
+
+Comparisons bind tighter than "and", which binds tighter than "or", so the query above is equivalent to:
+
+    . where ((.id == 1) and (.name == "foobar")) or (.mobile.type == "iPhone")
+
+Parentheses can be used to group conditions differently:
+
+    . where .id == 1 and (.name == "foobar" or .mobile.type == "iPhone")
+
+"not" binds tighter than "and" and "or" but looser than comparisons: "not .id == 1 and .b == 2"
+is equivalent to "(not (.id == 1)) and (.b == 2)".
 */
 package haddoque
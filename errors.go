@@ -0,0 +1,95 @@
+package haddoque
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned by Compile when a query is malformed.
+//
+// It carries enough information to point the user at the exact location of the problem.
+type SyntaxError struct {
+	// Query is the complete query text.
+	Query string
+	// Offset is the byte offset of the offending token in the query.
+	Offset int
+	// Line is the line of the offending token, starting at 1.
+	Line int
+	// Column is the column of the offending token in runes, starting at 1.
+	Column int
+	// Token is the text of the offending token. It is empty at the end of the query.
+	Token string
+	// Expected lists what would have been valid at this position, if known.
+	Expected []string
+	// Msg is the description of the error.
+	Msg string
+}
+
+func newSyntaxError(query string, offset int, tok string, expected []string, msg string) *SyntaxError {
+	if offset > len(query) {
+		offset = len(query)
+	}
+
+	lineStart := strings.LastIndex(query[:offset], "\n") + 1
+
+	return &SyntaxError{
+		Query:    query,
+		Offset:   offset,
+		Line:     strings.Count(query[:offset], "\n") + 1,
+		Column:   utf8.RuneCountInString(query[lineStart:offset]) + 1,
+		Token:    tok,
+		Expected: expected,
+		Msg:      msg,
+	}
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	if len(e.Expected) > 0 {
+		msg += ", expected " + strings.Join(e.Expected, " or ")
+	}
+
+	return msg
+}
+
+// Format renders the error with the offending line of the query and a caret under the problem, for example:
+//
+//	line 1, column 18: unexpected ","
+//	    . where (.id == 1, .name)
+//	                     ^
+//	expected operator or ")"
+func (e *SyntaxError) Format() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "line %d, column %d: %s\n", e.Line, e.Column, e.Msg)
+
+	lines := strings.Split(e.Query, "\n")
+	line := ""
+	if e.Line-1 < len(lines) {
+		line = lines[e.Line-1]
+	}
+
+	// tabs are kept in the padding so that the caret lines up with the query line
+	var pad bytes.Buffer
+	for i, r := range []rune(line) {
+		if i >= e.Column-1 {
+			break
+		}
+		if r == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+
+	fmt.Fprintf(&buf, "    %s\n", line)
+	fmt.Fprintf(&buf, "    %s^\n", pad.String())
+
+	if len(e.Expected) > 0 {
+		fmt.Fprintf(&buf, "expected %s\n", strings.Join(e.Expected, " or "))
+	}
+
+	return buf.String()
+}
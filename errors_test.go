@@ -0,0 +1,95 @@
+package haddoque_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vrischmann/haddoque"
+)
+
+type syntaxErrorTest struct {
+	query  string
+	line   int
+	column int
+	token  string
+	msg    string
+}
+
+var syntaxErrorTests = []syntaxErrorTest{
+	{`. where (.id == 1, .name)`, 1, 18, ",", `unexpected ","`},
+	{`. where .name = "foobar"`, 1, 15, "=", "expected = after ="},
+	{`. where .name == "foobar`, 1, 18, `"foobar`, "unterminated quoted string"},
+	{`. where .id in [1, 2`, 1, 21, "", "unexpected end of query"},
+	{`. where`, 1, 3, "where", "missing condition after where"},
+	{".id,\n  .name\n\twhere (.id == 1 $)", 3, 18, "$", "query is malformed"},
+	{`.name where (.age == 1é)`, 1, 22, "1é", `bad number syntax: "1é"`},
+	{`.name where .age == 1e+`, 1, 21, "1e+", `bad number syntax: "1e+"`},
+	{`.name where .age == 1e400`, 1, 21, "1e400", `bad number syntax "1e400"`},
+	{`. where .path matches "[a-"`, 1, 23, `"[a-"`, "invalid pattern \"[a-\": error parsing regexp: missing closing ]: `[a-`"},
+	{`. where .path like .host`, 1, 20, ".host", `unexpected ".host"`},
+	{`. where foo(.a) == 1`, 1, 9, "foo", `function "foo" not defined`},
+	{`. where lower(.a, .b) == 1`, 1, 9, "lower", "wrong number of arguments for lower: want 1, got 2"},
+	{`. where substr(.a) == 1`, 1, 9, "substr", "wrong number of arguments for substr: want at least 2, got 1"},
+	{`. where lower(.a == 1`, 1, 22, "", "unexpected end of query"},
+	{`. where not .deleted`, 1, 9, "not", "invalid condition after not"},
+	{`. where !.deleted`, 1, 9, "!", "invalid condition after !"},
+	{`. where not 1`, 1, 9, "not", "invalid condition after not"},
+	{`. where .a == 2 or .deleted`, 1, 17, "or", "invalid condition after or"},
+	{`. where .deleted or .a == 2`, 1, 18, "or", "invalid condition before or"},
+	{`. where .a == 1 and 5`, 1, 17, "and", "invalid condition after and"},
+	{`. where .a == 1 == 2`, 1, 9, ".a", "operand of == must be a value"},
+	{`. where (.a == 1) + 2 == 3`, 1, 9, "(", "operand of + must be a value"},
+	{`. where .x in (.a == 1)`, 1, 15, "(", "operand of in must be a value"},
+	{`. where .tags contains exists(.a)`, 1, 24, "exists", "operand of contains must be a value"},
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, test := range syntaxErrorTests {
+		_, err := haddoque.Compile(test.query)
+
+		serr, isSyntaxErr := err.(*haddoque.SyntaxError)
+		assert(t, isSyntaxErr, "expected a *SyntaxError for %q, got %#v", test.query, err)
+
+		equals(t, test.query, serr.Query)
+		equals(t, test.line, serr.Line)
+		equals(t, test.column, serr.Column)
+		equals(t, test.token, serr.Token)
+		equals(t, test.msg, serr.Msg)
+	}
+}
+
+func TestSyntaxErrorExpected(t *testing.T) {
+	_, err := haddoque.Compile(`. where .id in [1, where]`)
+	serr := err.(*haddoque.SyntaxError)
+
+	equals(t, 19, serr.Offset)
+	equals(t, []string{"field", "literal", `"("`}, serr.Expected)
+	equals(t, `syntax error at line 1, column 20: unexpected "where", expected field or literal or "("`, serr.Error())
+}
+
+func TestSyntaxErrorFormat(t *testing.T) {
+	_, err := haddoque.Compile(".id,\n\t.name where (.id == 1, .name)")
+	serr := err.(*haddoque.SyntaxError)
+
+	exp := "line 2, column 23: unexpected \",\"\n" +
+		"    \t.name where (.id == 1, .name)\n" +
+		"    \t                     ^\n" +
+		"expected operator or \")\"\n"
+	equals(t, exp, serr.Format())
+}
+
+// compileErrorTest is a query whose compilation fails with a syntax error.
+type compileErrorTest struct {
+	query string
+	msg   string
+}
+
+func testCompileErrors(t *testing.T, tests []compileErrorTest) {
+	for _, test := range tests {
+		_, err := haddoque.Compile(test.query)
+
+		var serr *haddoque.SyntaxError
+		assert(t, errors.As(err, &serr), "%s: expected a syntax error, got %v", test.query, err)
+		equals(t, test.msg, serr.Msg)
+	}
+}
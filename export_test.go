@@ -0,0 +1,8 @@
+package haddoque
+
+import "time"
+
+// SetDeduplicatorClock replaces the clock used by the deduplicator to expire the keys.
+func SetDeduplicatorClock(d *Deduplicator, now func() time.Time) {
+	d.now = now
+}
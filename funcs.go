@@ -0,0 +1,399 @@
+package haddoque
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// this is heavily based on the function handling of text/template
+
+// FuncMap is the type of the map defining the mapping from names to functions,
+// like text/template.FuncMap.
+//
+// Each function must have either a single return value, or two return values of which
+// the second has type error. If the second return value is non-nil during evaluation,
+// the call evaluates to nothing, which makes any condition using it false.
+// A call which panics evaluates to nothing as well, unless the panic is a runtime error such as
+// a nil pointer dereference, which is a bug of the function and is propagated.
+//
+// The results are handled like the values of a Go object given to Query.Exec: a named type like
+// "type Country string" is a string, and a map or a struct is an object.
+//
+// The arguments of a call are converted to the types of the function parameters:
+// numbers are converted to any numeric type as long as they are in range and no precision
+// is lost, so a negative number is never converted to an unsigned type, and
+// parameters of type interface{} accept any value. A string or a boolean is converted to a named
+// type of the same kind, like "type Country string".
+type FuncMap map[string]interface{}
+
+// WithFuncs adds the functions of the map to the functions which can be called by the query.
+// A function of the map takes precedence over a builtin function or an aggregate of the same name.
+//
+// WithFuncs panics if a value in the map is not a function with an appropriate signature
+// or if a name can't be used as a function name in a query.
+func WithFuncs(funcs FuncMap) Option {
+	for name, fn := range funcs {
+		if !goodName(name) {
+			panic(fmt.Errorf("haddoque: function name %q is not a valid identifier", name))
+		}
+
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func {
+			panic(fmt.Errorf("haddoque: value for %q is not a function", name))
+		}
+
+		if !goodFunc(v.Type()) {
+			panic(fmt.Errorf("haddoque: can't install function %q with %d results", name, v.Type().NumOut()))
+		}
+	}
+
+	return func(q *Query) {
+		if q.funcs == nil {
+			q.funcs = make(map[string]reflect.Value)
+		}
+
+		for name, fn := range funcs {
+			q.funcs[name] = reflect.ValueOf(fn)
+		}
+	}
+}
+
+// goodName reports whether the name can be lexed as a function name.
+func goodName(name string) bool {
+	l := newLexer(name)
+	lex := l.nextLexeme()
+
+	return lex.tok == tokIdentifier && lex.val == name && l.nextLexeme().tok == tokEOF
+}
+
+// goodFunc reports whether the function has one result, or two results of which the second is an error.
+func goodFunc(typ reflect.Type) bool {
+	switch {
+	case typ.NumOut() == 1:
+		return true
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+		return true
+	}
+
+	return false
+}
+
+// builtins are the functions available in every query.
+var builtins = map[string]interface{}{
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"trim":         strings.TrimSpace,
+	"len":          length,
+	"substr":       substr,
+	"starts_with":  strings.HasPrefix,
+	"ends_with":    strings.HasSuffix,
+	"contains_str": strings.Contains,
+	"split":        strings.Split,
+	"concat":       concat,
+	"replace":      replace,
+}
+
+var builtinFuncs = makeFuncs(builtins)
+
+func makeFuncs(m map[string]interface{}) map[string]reflect.Value {
+	res := make(map[string]reflect.Value, len(m))
+	for name, fn := range m {
+		res[name] = reflect.ValueOf(fn)
+	}
+
+	return res
+}
+
+// findFunction looks for a function in the functions of the tree first, then in the builtins.
+func (t *tree) findFunction(name string) (reflect.Value, bool) {
+	if fn, ok := t.funcs[name]; ok {
+		return fn, true
+	}
+
+	fn, ok := builtinFuncs[name]
+	return fn, ok
+}
+
+// aggregates are the names of the aggregate functions, which are computed over groups of objects.
+var aggregates = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+// isAggregate reports whether name is an aggregate function, which it isn't if the query defines a function of the same name.
+func (t *tree) isAggregate(name string) bool {
+	_, ok := t.funcs[name]
+	return aggregates[name] && !ok
+}
+
+// argType returns the type of the i-th argument of the function.
+func argType(typ reflect.Type, i int) reflect.Type {
+	if typ.IsVariadic() && i >= typ.NumIn()-1 {
+		return typ.In(typ.NumIn() - 1).Elem()
+	}
+
+	return typ.In(i)
+}
+
+// checkArg reports whether the argument n can be converted to typ.
+//
+// Only the arguments of which the value or type is known before evaluation are checked:
+// literals and the results of function calls.
+func checkArg(n node, typ reflect.Type) bool {
+	switch v := n.(type) {
+	case *nullNode, *boolNode, *textNode, *numberNode:
+		val, _ := getValue(n, nil)
+		_, ok := convertArg(val, typ)
+		return ok
+	case *callNode:
+		// the value of an interface{} result is only known when the function is called
+		out := v.fn.Type().Out(0)
+		return kindCategory(out) == kindCategory(typ) || typ.Kind() == reflect.Interface || out.Kind() == reflect.Interface
+	default:
+		return true
+	}
+}
+
+// kindCategory groups the kinds which can be converted to each other by convertArg.
+func kindCategory(typ reflect.Type) reflect.Kind {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	default:
+		return typ.Kind()
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// call calls the function of the node with the values of its arguments.
+//
+// The result is not present if an argument is not present or can't be converted to the type
+// expected by the function, or if the function returns an error or panics with anything but a runtime error.
+func call(n *callNode, on *objNode) (res interface{}, present bool) {
+	typ := n.fn.Type()
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			res, present = nil, false
+		}
+	}()
+
+	args := make([]reflect.Value, len(n.args))
+	for i, arg := range n.args {
+		val, ok := getValue(arg, on)
+		if !ok {
+			return nil, false
+		}
+
+		args[i], ok = convertArg(val, argType(typ, i))
+		if !ok {
+			return nil, false
+		}
+	}
+
+	out := n.fn.Call(args)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, false
+	}
+
+	return normalizeResult(out[0]), true
+}
+
+// convertArg converts a value of the object to the type of a function argument.
+func convertArg(val interface{}, typ reflect.Type) (reflect.Value, bool) {
+	if val == nil {
+		switch typ.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice, reflect.Ptr:
+			return reflect.Zero(typ), true
+		default:
+			return reflect.Value{}, false
+		}
+	}
+
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(typ) {
+		return v, true
+	}
+
+	if k := typ.Kind(); (k == reflect.String || k == reflect.Bool) && v.Kind() == k {
+		return v.Convert(typ), true
+	}
+
+	if kindCategory(typ) != reflect.Float64 {
+		return reflect.Value{}, false
+	}
+
+	n, ok := normalizeNumber(val)
+	if !ok {
+		return reflect.Value{}, false
+	}
+
+	res := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := exactInt(n)
+		if !ok || res.OverflowInt(i) {
+			return reflect.Value{}, false
+		}
+		res.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, ok := exactUint(n)
+		if !ok || res.OverflowUint(u) {
+			return reflect.Value{}, false
+		}
+		res.SetUint(u)
+	default:
+		f, ok := exactFloat(n, typ.Bits())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		res.SetFloat(f)
+	}
+
+	return res, true
+}
+
+// exactInt converts a normalized number to an int64 if it is an integer in range.
+func exactInt(n interface{}) (int64, bool) {
+	switch t := n.(type) {
+	case int64:
+		return t, true
+	case float64:
+		if t == math.Trunc(t) && t >= math.MinInt64 && t < math.MaxInt64 {
+			return int64(t), true
+		}
+	}
+
+	return 0, false
+}
+
+// exactUint converts a normalized number to a uint64 if it is a positive integer in range.
+func exactUint(n interface{}) (uint64, bool) {
+	switch t := n.(type) {
+	case int64:
+		if t >= 0 {
+			return uint64(t), true
+		}
+	case uint64:
+		return t, true
+	case *big.Int:
+		if t.IsUint64() {
+			return t.Uint64(), true
+		}
+	case float64:
+		if t == math.Trunc(t) && t >= 0 && t < math.MaxUint64 {
+			return uint64(t), true
+		}
+	}
+
+	return 0, false
+}
+
+// exactFloat converts a normalized number to a float of the given size if no precision is lost.
+func exactFloat(n interface{}, bits int) (float64, bool) {
+	if f, ok := n.(float64); ok {
+		if bits == 32 && float64(float32(f)) != f && !math.IsNaN(f) {
+			return 0, false
+		}
+		return f, true
+	}
+
+	if bits == 32 {
+		f, acc := toBigFloat(n).Float32()
+		return float64(f), acc == big.Exact
+	}
+
+	f, acc := toBigFloat(n).Float64()
+	return f, acc == big.Exact
+}
+
+// normalizeResult converts the result of a function to the types used in decoded JSON.
+func normalizeResult(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return basicValue(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = normalizeResult(v.Index(i))
+		}
+
+		return res
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return normalizeResult(v.Elem())
+	default:
+		// any other value is converted like a Go object given as input
+		var c cycleDetector
+		on := newObjNode1(&objNode{}, "", v.Interface(), &c)
+		if on == nil {
+			return nil
+		}
+		return on.data()
+	}
+}
+
+var errNoLength = errors.New("value has no length")
+
+// length returns the number of characters of a string, elements of an array or fields of an object.
+func length(v interface{}) (int, error) {
+	switch t := v.(type) {
+	case string:
+		return utf8.RuneCountInString(t), nil
+	case []interface{}:
+		return len(t), nil
+	case map[string]interface{}:
+		return len(t), nil
+	default:
+		return 0, errNoLength
+	}
+}
+
+// substr returns the substring of s starting at the character start, of at most length characters if given.
+//
+// A negative start counts from the end of the string.
+func substr(s string, start int, length ...int) string {
+	runes := []rune(s)
+
+	start = clampIndex(start, len(runes))
+	end := len(runes)
+	if len(length) > 0 && length[0] >= 0 && length[0] < end-start {
+		end = start + length[0]
+	}
+
+	return string(runes[start:end])
+}
+
+// concat concatenates all its arguments.
+func concat(s ...string) string {
+	return strings.Join(s, "")
+}
+
+// replace replaces all the occurrences of old by new in s.
+func replace(s, old, new string) string {
+	return strings.Replace(s, old, new, -1)
+}
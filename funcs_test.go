@@ -0,0 +1,66 @@
+package haddoque
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+type callTest struct {
+	name     string
+	args     []interface{}
+	expected interface{}
+	ok       bool
+}
+
+var callTests = []callTest{
+	{"lower", []interface{}{"FooBar"}, "foobar", true},
+	{"upper", []interface{}{"FooBar"}, "FOOBAR", true},
+	{"trim", []interface{}{"  foo \n"}, "foo", true},
+	{"len", []interface{}{"héhé"}, int64(4), true},
+	{"len", []interface{}{[]interface{}{1, 2}}, int64(2), true},
+	{"len", []interface{}{map[string]interface{}{"a": 1}}, int64(1), true},
+	{"len", []interface{}{int64(10)}, nil, false},
+	{"len", []interface{}{nil}, nil, false},
+	{"substr", []interface{}{"héllo", int64(1)}, "éllo", true},
+	{"substr", []interface{}{"héllo", int64(1), int64(2)}, "él", true},
+	{"substr", []interface{}{"héllo", int64(-2)}, "lo", true},
+	{"substr", []interface{}{"héllo", int64(3), int64(10)}, "lo", true},
+	{"substr", []interface{}{"héllo", int64(10)}, "", true},
+	{"substr", []interface{}{"héllo", int64(1), int64(math.MaxInt64)}, "éllo", true},
+	{"substr", []interface{}{"héllo", int64(math.MinInt64), int64(math.MaxInt64)}, "héllo", true},
+	{"substr", []interface{}{"héllo", 1.0}, "éllo", true},
+	{"substr", []interface{}{"héllo", 1.5}, nil, false},
+	{"starts_with", []interface{}{"foobar", "foo"}, true, true},
+	{"ends_with", []interface{}{"foobar", "foo"}, false, true},
+	{"contains_str", []interface{}{"foobar", "oba"}, true, true},
+	{"split", []interface{}{"a,b", ","}, []interface{}{"a", "b"}, true},
+	{"concat", []interface{}{}, "", true},
+	{"concat", []interface{}{"a", "b", "c"}, "abc", true},
+	{"replace", []interface{}{"a-b-c", "-", "+"}, "a+b+c", true},
+	{"lower", []interface{}{int64(1)}, nil, false},
+}
+
+func TestBuiltins(t *testing.T) {
+	for _, test := range callTests {
+		fn, found := newTree(nil).findFunction(test.name)
+		equals(t, true, found)
+
+		// the arguments are passed as fields of the object
+		obj := make(map[string]interface{})
+		n := &callNode{nodeType: nodeCall, name: test.name, fn: fn}
+		for i, arg := range test.args {
+			name := "arg" + strconv.Itoa(i)
+			obj[name] = arg
+			n.args = append(n.args, &chainNode{
+				nodeType: nodeChain,
+				chain:    "." + name,
+				steps:    []step{{kind: stepField, name: name}},
+			})
+		}
+
+		res, ok := call(n, newObjNode(obj))
+		assert(t, ok == test.ok, "expected ok to be %v for %s%v", test.ok, test.name, test.args)
+		equals(t, test.expected, res)
+	}
+}
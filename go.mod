@@ -0,0 +1,3 @@
+module github.com/vrischmann/haddoque
+
+go 1.17
@@ -2,55 +2,301 @@ package haddoque
 
 import (
 	"errors"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
 var (
 	// ErrNonExistingFields is returned when some fields in the query do not exist.
+	//
+	// The error actually returned is a *MissingFieldsError, use errors.Is to compare against ErrNonExistingFields.
 	ErrNonExistingFields = errors.New("some requested fields do not exist")
 	// ErrInvalidObject is returned when the object given to execute the query against is invalid.
 	ErrInvalidObject = errors.New("unable to use the provided object")
+	// ErrAggregateQuery is returned when a query with aggregates is executed on a single object instead of with an Aggregator.
+	ErrAggregateQuery = errors.New("aggregate query must be executed with an Aggregator")
+	// ErrNotAggregateQuery is returned by NewAggregator for a query without aggregates.
+	ErrNotAggregateQuery = errors.New("query has no aggregate")
+	// ErrNotDistinctQuery is returned by NewDeduplicator for a query without DISTINCT clause.
+	ErrNotDistinctQuery = errors.New("query has no distinct clause")
 )
 
-// Exec executes the given query on the given map data.
-func Exec(query string, obj map[string]interface{}) (interface{}, error) {
+// MissingFieldsError is returned when some selected fields do not exist in the object.
+type MissingFieldsError struct {
+	// Paths are the selectors of the missing fields, as written in the query.
+	Paths []string
+}
+
+func (e *MissingFieldsError) Error() string {
+	return ErrNonExistingFields.Error() + ": " + strings.Join(e.Paths, ", ")
+}
+
+// Is makes errors.Is(err, ErrNonExistingFields) true for a *MissingFieldsError.
+func (e *MissingFieldsError) Is(target error) bool {
+	return target == ErrNonExistingFields
+}
+
+// MissingFields defines how a query handles the selected fields which do not exist in an object.
+type MissingFields int
+
+const (
+	// MissingError makes Exec fail with a *MissingFieldsError. Optional fields are omitted from the result.
+	// This is the default.
+	MissingError MissingFields = iota
+	// MissingAsNull sets the missing fields to null in the result.
+	MissingAsNull
+	// MissingOmit omits the missing fields from the result.
+	MissingOmit
+)
+
+// Option configures a query at compile time.
+type Option func(q *Query)
+
+// WithMissingFields sets how the query handles the selected fields which do not exist in an object.
+func WithMissingFields(m MissingFields) Option {
+	return func(q *Query) {
+		q.missing = m
+	}
+}
+
+// Query is a compiled query.
+//
+// A Query is immutable once compiled and safe for concurrent use by multiple goroutines.
+type Query struct {
+	text      string
+	root      *seqNode
+	missing   MissingFields
+	funcs     map[string]reflect.Value
+	paths     *pathTrie
+	group     *groupNode // nil without GROUP BY clause
+	order     *orderNode // nil without ORDER BY clause
+	limit     int        // -1 without LIMIT clause
+	offset    int
+	distinct  *distinctNode // nil without DISTINCT clause
+	aggregate bool
+}
+
+// Compile parses a query and returns, if successful, a Query that can be executed
+// against any number of objects.
+func Compile(query string, opts ...Option) (*Query, error) {
+	q := &Query{text: query}
+	for _, opt := range opts {
+		opt(q)
+	}
+
 	lexer := newLexer(query)
-	lexer.lex()
 	tr := newTree(lexer)
+	tr.funcs = q.funcs
 
 	err := tr.parse()
 	if err != nil {
 		return nil, err
 	}
+	q.root = tr.root
+	q.paths = queryPaths(q.root)
+
+	q.limit = -1
+	for _, v := range q.root.nodes {
+		switch v := v.(type) {
+		case *groupNode:
+			q.group = v
+		case *orderNode:
+			q.order = v
+		case *distinctNode:
+			q.distinct = v
+		case *limitNode:
+			if v.clause == tokLimit {
+				q.limit = v.n
+			} else {
+				q.offset = v.n
+			}
+		}
+	}
+	q.aggregate = q.group != nil || hasAggregates(q.root)
+
+	return q, nil
+}
+
+// MustCompile is like Compile but panics if the query cannot be parsed.
+// It simplifies safe initialization of global variables holding compiled queries.
+func MustCompile(query string, opts ...Option) *Query {
+	q, err := Compile(query, opts...)
+	if err != nil {
+		panic(`haddoque: Compile(` + strconv.Quote(query) + `): ` + err.Error())
+	}
+
+	return q
+}
+
+// String returns the source text used to compile the query.
+func (q *Query) String() string {
+	return q.text
+}
+
+// Tree returns an indented representation of the parsed query, one node per line.
+//
+// It's meant to debug queries, its format may change.
+func (q *Query) Tree() string {
+	return printIndentRoot(q.root)
+}
+
+// IsAggregate reports whether the query has aggregates or a GROUP BY clause,
+// in which case it must be executed with an Aggregator.
+func (q *Query) IsAggregate() bool {
+	return q.aggregate
+}
+
+// IsCollection reports whether the query has an ORDER BY, LIMIT or OFFSET clause. These clauses apply
+// to the results of many objects, which are gathered with a Collector, or an Aggregator for an aggregate query.
+func (q *Query) IsCollection() bool {
+	return q.order != nil || q.limit >= 0 || q.offset > 0
+}
+
+// IsDistinct reports whether the query has a DISTINCT clause, which is applied by a Deduplicator.
+func (q *Query) IsDistinct() bool {
+	return q.distinct != nil
+}
+
+// Exec executes the query on the given data.
+//
+// The data can be a map[string]interface{} as decoded by encoding/json, or a struct, a map, a slice
+// or a pointer to them, which are queried like their JSON encoding. ErrInvalidObject is returned
+// for any other value.
+//
+// ErrAggregateQuery is returned for a query with aggregates, see Aggregator.
+// The DISTINCT, ORDER BY, LIMIT and OFFSET clauses are ignored, see Deduplicator and Collector.
+func (q *Query) Exec(obj interface{}) (interface{}, error) {
+	res, _, err := q.exec(obj)
+	return res, err
+}
+
+// ExecMatch executes the query on the given data like Exec, also reporting whether the data matched.
+//
+// Unlike Exec, it tells a matching object from one which doesn't match even when the result is nil,
+// which happens when all the selected fields are omitted.
+func (q *Query) ExecMatch(obj interface{}) (interface{}, bool, error) {
+	return q.exec(obj)
+}
+
+// exec executes the query on the given data, also reporting whether the data matched.
+//
+// The result can be nil for a matching object if all the selected fields are omitted.
+func (q *Query) exec(obj interface{}) (interface{}, bool, error) {
+	if q.aggregate {
+		return nil, false, ErrAggregateQuery
+	}
 
 	on := newObjNode(obj)
 	if on == nil {
-		return nil, ErrInvalidObject
+		return nil, false, ErrInvalidObject
 	}
 
-	if !checkFields(tr.root, on) {
-		return nil, ErrNonExistingFields
+	return q.execNode(on)
+}
+
+// execNode executes the query on an objNode, also reporting whether it matched.
+func (q *Query) execNode(on *objNode) (interface{}, bool, error) {
+	if missing := q.missingFields(on); len(missing) > 0 {
+		return nil, false, &MissingFieldsError{Paths: missing}
 	}
 
-	if !evaluateWhere(tr.root, on) {
-		return nil, nil
+	if !evaluateWhere(q.root, on) {
+		return nil, false, nil
 	}
 
-	return getFields(tr.root, on)
+	res, err := getFields(q.root, on, q.missing)
+	return res, err == nil, err
 }
 
-func checkFields(root *seqNode, on *objNode) bool {
-	for _, v := range root.nodes {
-		if v.typ() != nodeChain {
-			break
+// ExecBytes executes the query on a JSON document.
+//
+// Only the parts of the document used by the query are decoded, the rest is skipped,
+// which makes it much faster than decoding the whole document and calling Exec.
+// Numbers are decoded as json.Number so no precision is lost.
+//
+// An *InvalidJSONError is returned if the data is not valid JSON.
+func (q *Query) ExecBytes(data []byte) (interface{}, error) {
+	res, _, err := q.execBytes(data)
+	return res, err
+}
+
+func (q *Query) execBytes(data []byte) (interface{}, bool, error) {
+	obj, err := extractJSON(data, q.paths)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return q.exec(obj)
+}
+
+// Match reports whether the given data satisfies the query.
+//
+// The object matches if Exec wouldn't fail because of missing fields and the conditions, if any, evaluate to true.
+// Like Exec fails with ErrAggregateQuery, no object matches a query with aggregates.
+func (q *Query) Match(obj interface{}) bool {
+	if q.aggregate {
+		return false
+	}
+
+	on := newObjNode(obj)
+	if on == nil {
+		return false
+	}
+
+	return len(q.missingFields(on)) == 0 && evaluateWhere(q.root, on)
+}
+
+// Exec executes the given query on the given data.
+//
+// It is a shortcut for Compile followed by Query.Exec; if the same query is executed
+// many times, compile it once and reuse the Query instead.
+func Exec(query string, obj interface{}) (interface{}, error) {
+	q, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Exec(obj)
+}
+
+// missingFields returns the selectors of the fields missing in the objNode which make the query fail.
+func (q *Query) missingFields(on *objNode) []string {
+	if q.missing != MissingError {
+		return nil
+	}
+
+	var res []string
+	for _, v := range projections(q.root) {
+		chain, ok := unalias(v).(*chainNode)
+		if ok && !chain.optional && !hasChain(chain, on) {
+			res = append(res, chain.chain)
 		}
+	}
 
-		if !on.hasPath(v.(*chainNode).chain) {
-			return false
+	return res
+}
+
+// projections returns the field selectors, computed fields and aggregates of the query.
+func projections(root *seqNode) []node {
+	for i, v := range root.nodes {
+		switch v.typ() {
+		case nodeChain, nodeCall, nodeAggregate, nodeComputed, nodeAlias:
+		default:
+			return root.nodes[:i]
 		}
 	}
 
-	return true
+	return root.nodes
+}
+
+// unalias returns the expression of a renamed field, or the node itself.
+func unalias(n node) node {
+	if a, ok := n.(*aliasNode); ok {
+		return a.expr
+	}
+
+	return n
 }
 
 func evaluateWhere(root *seqNode, on *objNode) bool {
@@ -77,61 +323,130 @@ func evaluateCondition(cond node, on *objNode) bool {
 	case *orNode:
 		return evaluateCondition(v.left, on) || evaluateCondition(v.right, on)
 	case *inNode:
-		if v.right.typ() != nodeSeq {
+		lval, ok := getValue(v.left, on)
+		if !ok {
 			return false
 		}
 
-		lval := on.get(v.left.(*chainNode).chain)
-		l := v.right.(*seqNode)
-		for _, el := range l.nodes {
-			elVal := getValue(el, on)
-			if evaluateEq(lval, elVal) {
-				return true
-			}
+		rval, ok := getValue(v.right, on)
+		if !ok {
+			return false
 		}
 
-		return false
+		seq, ok := rval.([]interface{})
+		return ok && hasElement(seq, lval)
 	case *containsNode:
-		if v.left.typ() != nodeChain {
+		lval, ok := getValue(v.left, on)
+		if !ok {
 			return false
 		}
 
-		lval := on.get(v.left.(*chainNode).chain)
-		rval := getValue(v.right, on)
+		rval, ok := getValue(v.right, on)
+		if !ok {
+			return false
+		}
 
 		seq, ok := lval.([]interface{})
 		if !ok {
 			return false
 		}
 
-		for _, el := range seq {
-			if evaluateEq(rval, el) {
-				return true
+		// an array on the right must be entirely contained
+		if els, ok := rval.([]interface{}); ok {
+			for _, el := range els {
+				if !hasElement(seq, el) {
+					return false
+				}
 			}
+
+			return true
 		}
 
-		return false
+		return hasElement(seq, rval)
 	case *operationNode:
 		return evaluateOperationNode(v, on)
+	case *quantifierNode:
+		return evaluateQuantifier(v, on)
+	case *notNode:
+		return !evaluateCondition(v.condition, on)
+	case *isNullNode:
+		// an absent field is considered null
+		val, ok := getValue(v.left, on)
+		return (!ok || val == nil) != v.negate
+	case *existsNode:
+		return len(on.resolve(v.chain.(*chainNode).steps)) > 0
+	case *matchNode:
+		val, _ := getValue(v.left, on)
+		str, ok := val.(string)
+		return ok && v.re.MatchString(str)
+	case *callNode:
+		val, _ := call(v, on)
+		b, ok := val.(bool)
+		return ok && b
 	}
 
 	return false
 }
 
-func evaluateOperationNode(n *operationNode, on *objNode) bool {
-	// TODO(vincent): do we want to support something else as LHS ?
-	if n.left.typ() != nodeChain {
+// hasElement reports whether one of the elements of seq is equal to val.
+func hasElement(seq []interface{}, val interface{}) bool {
+	for _, el := range seq {
+		if evaluateEq(val, el) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluateQuantifier evaluates the condition of the quantifier with each element of the array as the root object.
+func evaluateQuantifier(n *quantifierNode, on *objNode) bool {
+	elems, ok := elements(n.seq.(*chainNode), on)
+	if !ok {
 		return false
 	}
 
-	lval := on.get(n.left.(*chainNode).chain) // we know for sure the object has that field at this point in time
+	for _, el := range elems {
+		res := evaluateCondition(n.condition, el)
 
-	// TODO(vincent): does the parser allow non value nodes here ? need to check
-	rval := getValue(n.right, on)
-	if rval == nil {
+		switch {
+		case n.quantifier == tokAny && res:
+			return true
+		case n.quantifier == tokAll && !res:
+			return false
+		case n.quantifier == tokNone && res:
+			return false
+		}
+	}
+
+	return n.quantifier != tokAny
+}
+
+// evaluateOperationNode evaluates a comparison. Both operands can be any expression having a value:
+// a field, a literal, a function call or an arithmetic expression.
+func evaluateOperationNode(n *operationNode, on *objNode) bool {
+	lval, ok := getValue(n.left, on)
+	if !ok {
+		return false
+	}
+
+	rval, ok := getValue(n.right, on)
+	if !ok {
 		return false
 	}
 
+	// null is only equal to itself and can't be ordered
+	if lval == nil || rval == nil {
+		switch n.operator {
+		case tokEq:
+			return lval == nil && rval == nil
+		case tokNeq:
+			return (lval == nil) != (rval == nil)
+		default:
+			return false
+		}
+	}
+
 	switch n.operator {
 	case tokLt: // <
 		return evaluateLt(lval, rval)
@@ -140,231 +455,265 @@ func evaluateOperationNode(n *operationNode, on *objNode) bool {
 	case tokGt: // >
 		return evaluateGt(lval, rval)
 	case tokGte: // >=
-		return evaluateGt(lval, rval)
+		return evaluateGte(lval, rval)
 	case tokEq: // ==
 		return evaluateEq(lval, rval)
 	case tokNeq: // !=
 		return evaluateNeq(lval, rval)
-	case tokNot: // !
-		return false // TODO(vincent): unsupported, can we handle this ?
 	}
 
 	return false
 }
 
 // getFields selects the wanted fields from the objNode
-func getFields(root *seqNode, on *objNode) (interface{}, error) {
-	var nodes []*objNode
-
-	// Beware: this is ugly code
-
-	for _, v := range root.nodes {
-		if v.typ() != nodeChain {
+//
+// The selected fields are placed in a new object at the same location they have in the source object,
+// computed fields are placed at the top level with the text of their expression as the key,
+// and renamed fields at the location given by their alias.
+// Missing fields are set to null with MissingAsNull, and omitted otherwise.
+func getFields(root *seqNode, on *objNode, missing MissingFields) (interface{}, error) {
+	var res interface{}
+
+	fields := projections(root)
+
+	// the root field selects the whole object, the other selected fields are already part of it
+	whole := false
+	for _, v := range fields {
+		if chain, ok := v.(*chainNode); ok && len(chain.steps) == 0 {
+			res, whole = on.data(), true
 			break
 		}
-
-		chain := v.(*chainNode).chain
-		nodes = append(nodes, on.findSubNode(chain))
 	}
 
-	return mergeNodes(nodes), nil
-}
+	for _, v := range fields {
+		switch v := v.(type) {
+		case *chainNode:
+			if whole {
+				continue
+			}
 
-func getValue(n node, on *objNode) interface{} {
-	switch v := n.(type) {
-	case *chainNode:
-		return on.findSubNode(v.chain).data()
-	case *boolNode:
-		return v.val
-	case *textNode:
-		return v.text
-	case *numberNode:
-		if v.isInt {
-			return v.intVal
+			if missing == MissingAsNull && !hasChain(v, on) {
+				if loc, ok := v.location(); ok {
+					res = project(res, loc, nil)
+				}
+				continue
+			}
+
+			for _, m := range on.resolve(v.steps) {
+				res = project(res, m.loc, m.node.data())
+			}
+		case *callNode, *computedNode:
+			res = projectValue(res, []interface{}{sourceText(v)}, v, on, missing)
+		case *aliasNode:
+			res = projectValue(res, v.location(), v.expr, on, missing)
 		}
+	}
 
-		return v.floatVal
+	return finishProjection(res), nil
+}
+
+// projectValue sets the value of the node at the location loc in res, like project.
+// An absent value is set to null with MissingAsNull, and omitted otherwise.
+func projectValue(res interface{}, loc []interface{}, n node, on *objNode, missing MissingFields) interface{} {
+	val, ok := getValue(n, on)
+	switch {
+	case ok:
+		return project(res, loc, val)
+	case missing == MissingAsNull:
+		return project(res, loc, nil)
 	default:
-		return nil
+		return res
 	}
 }
 
-func evaluateLt(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv < rv
-		}
+// hasChain reports whether the chain exists in the objNode.
+//
+// For chains selecting multiple elements, only the part before the first slice or wildcard needs to exist.
+func hasChain(c *chainNode, on *objNode) bool {
+	return len(on.resolve(c.steps[:c.firstMulti()])) > 0
+}
 
-		frv, ok := r.(float64)
-		return ok && lv < int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv < rv
+// lookup returns the value the chain points to in the objNode.
+//
+// Chains selecting multiple elements always return a slice of the selected values.
+func lookup(c *chainNode, on *objNode) (interface{}, bool) {
+	matches := on.resolve(c.steps)
+	if c.multi() {
+		res := make([]interface{}, len(matches))
+		for i, m := range matches {
+			res[i] = m.node.data()
 		}
 
-		irv, ok := r.(int64)
-		return ok && lv < float64(irv)
-	case string:
-		rv, ok := r.(string)
-		if !ok {
-			return false
-		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv < rv
-	default:
-		return false
+		return res, true
 	}
-}
 
-func evaluateLte(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv <= rv
-		}
+	if len(matches) == 0 {
+		return nil, false
+	}
 
-		frv, ok := r.(float64)
-		return ok && lv <= int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv <= rv
-		}
+	return matches[0].node.data(), true
+}
 
-		irv, ok := r.(int64)
-		return ok && lv <= float64(irv)
-	case string:
-		rv, ok := r.(string)
-		if !ok {
-			return false
-		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv <= rv
+// elements returns the nodes of the elements of the array the chain points to.
+//
+// Chains selecting multiple elements return the selected nodes.
+func elements(c *chainNode, on *objNode) ([]*objNode, bool) {
+	matches := on.resolve(c.steps)
+
+	switch {
+	case c.multi():
+	case len(matches) == 1 && matches[0].node.kind == objArray:
+		return matches[0].node.fields, true
 	default:
-		return false
+		return nil, false
+	}
+
+	res := make([]*objNode, len(matches))
+	for i, m := range matches {
+		res[i] = m.node
 	}
+
+	return res, true
 }
 
-func evaluateGt(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv > rv
+// getValue returns the value of the node, and whether it's present.
+//
+// A field which doesn't exist in the objNode is not present, whereas a field
+// holding null is present with a nil value.
+func getValue(n node, on *objNode) (interface{}, bool) {
+	switch v := n.(type) {
+	case *chainNode:
+		return lookup(v, on)
+	case *callNode:
+		return call(v, on)
+	case *arithNode:
+		return evaluateArith(v, on)
+	case *negNode:
+		return evaluateNeg(v, on)
+	case *computedNode:
+		return getValue(v.expr, on)
+	case *seqNode:
+		res := make([]interface{}, 0, len(v.nodes))
+		for _, el := range v.nodes {
+			if val, ok := getValue(el, on); ok {
+				res = append(res, val)
+			}
 		}
 
-		frv, ok := r.(float64)
-		return ok && lv > int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv > rv
+		return res, true
+	case *objectNode:
+		res := make(map[string]interface{}, len(v.keys))
+		for i, key := range v.keys {
+			if val, ok := getValue(v.values[i], on); ok {
+				res[key] = val
+			}
 		}
 
-		irv, ok := r.(int64)
-		return ok && lv > float64(irv)
-	case string:
-		rv, ok := r.(string)
-		if !ok {
-			return false
+		return res, true
+	case *nullNode:
+		return nil, true
+	case *boolNode:
+		return v.val, true
+	case *textNode:
+		return v.val, true
+	case *numberNode:
+		if v.bigVal != nil {
+			return v.bigVal, true
 		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv > rv
+		if v.isInt {
+			return v.intVal, true
+		}
+
+		return v.floatVal, true
 	default:
-		return false
+		return nil, false
 	}
 }
 
-func evaluateGte(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv >= rv
-		}
-
-		frv, ok := r.(float64)
-		return ok && lv >= int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv >= rv
-		}
-
-		irv, ok := r.(int64)
-		return ok && lv >= float64(irv)
-	case string:
-		rv, ok := r.(string)
+// compareValues compares two numbers or two strings, returning -1, 0 or +1.
+//
+// Numbers of any kind can be compared with each other, see compareNumbers.
+// Values of other types can't be ordered.
+func compareValues(l, r interface{}) (int, bool) {
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
 		if !ok {
-			return false
+			return 0, false
 		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv >= rv
-	default:
-		return false
+
+		return strings.Compare(ls, rs), true
 	}
+
+	return compareNumbers(l, r)
 }
 
-func evaluateEq(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv == rv
-		}
+func evaluateLt(l, r interface{}) bool {
+	c, ok := compareValues(l, r)
+	return ok && c < 0
+}
 
-		frv, ok := r.(float64)
-		return ok && lv == int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv == rv
-		}
+func evaluateLte(l, r interface{}) bool {
+	c, ok := compareValues(l, r)
+	return ok && c <= 0
+}
 
-		irv, ok := r.(int64)
-		return ok && lv == float64(irv)
+func evaluateGt(l, r interface{}) bool {
+	c, ok := compareValues(l, r)
+	return ok && c > 0
+}
+
+func evaluateGte(l, r interface{}) bool {
+	c, ok := compareValues(l, r)
+	return ok && c >= 0
+}
+
+// evaluateEq reports whether two values are equal with the JSON semantics:
+// values of different types are never equal, except numbers of different kinds,
+// arrays are equal if their elements are equal in the same order, objects
+// if they have the same keys with equal values.
+func evaluateEq(l, r interface{}) bool {
+	switch lv := l.(type) {
+	case nil:
+		return r == nil
+	case bool:
+		rv, ok := r.(bool)
+		return ok && lv == rv
 	case string:
 		rv, ok := r.(string)
-		if !ok {
+		return ok && lv == rv
+	case []interface{}:
+		rv, ok := r.([]interface{})
+		if !ok || len(lv) != len(rv) {
 			return false
 		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv == rv
-	default:
-		return false
-	}
-}
 
-func evaluateNeq(l, r interface{}) bool {
-	switch lv := l.(type) {
-	case int64:
-		rv, ok := r.(int64)
-		if ok {
-			return lv != rv
+		for i := range lv {
+			if !evaluateEq(lv[i], rv[i]) {
+				return false
+			}
 		}
 
-		frv, ok := r.(float64)
-		return ok && lv != int64(frv)
-	case float64:
-		rv, ok := r.(float64)
-		if ok {
-			return lv != rv
+		return true
+	case map[string]interface{}:
+		rv, ok := r.(map[string]interface{})
+		if !ok || len(lv) != len(rv) {
+			return false
 		}
 
-		irv, ok := r.(int64)
-		return ok && lv != float64(irv)
-	case string:
-		rv, ok := r.(string)
-		if !ok {
-			return false
+		for k, v := range lv {
+			el, ok := rv[k]
+			if !ok || !evaluateEq(v, el) {
+				return false
+			}
 		}
-		rv = strings.Trim(rv, `"`)
-		return ok && lv != rv
+
+		return true
 	default:
-		return false
+		c, ok := compareNumbers(l, r)
+		return ok && c == 0
 	}
 }
+
+func evaluateNeq(l, r interface{}) bool {
+	return !evaluateEq(l, r)
+}
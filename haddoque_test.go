@@ -3,11 +3,16 @@ package haddoque_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/vrischmann/haddoque"
@@ -49,6 +54,18 @@ var tests = []haddoqueTest{
 	{file: "3_complex_filter.txt"},
 	{file: "4_complex_filter_2.txt"},
 	{file: "5_in_filter.txt"},
+	{file: "6_array_index.txt"},
+	{file: "7_array_wildcard.txt"},
+	{file: "8_array_slice.txt"},
+	{file: "9_quantifier_filter.txt"},
+	{file: "10_not_filter.txt"},
+	{file: "11_null_filter.txt"},
+	{file: "12_pattern_filter.txt"},
+	{file: "13_functions.txt"},
+	{file: "14_arithmetic_filter.txt"},
+	{file: "15_field_comparison.txt"},
+	{file: "16_deep_equality.txt"},
+	{file: "17_projection_aliases.txt"},
 }
 
 func TestExec(t *testing.T) {
@@ -61,6 +78,1022 @@ func TestExec(t *testing.T) {
 	}
 }
 
+func TestExecBytes(t *testing.T) {
+	for _, test := range tests {
+		data, err := ioutil.ReadFile("testdata/" + test.file)
+		ok(t, err)
+		input := bytes.Split(data, []byte("---"))[0]
+
+		readTest(t, test.file, &test.data.input, &test.data.query, &test.data.expected)
+
+		q, err := haddoque.Compile(test.data.query)
+		ok(t, err)
+
+		res, err := q.ExecBytes(input)
+		ok(t, err)
+
+		// numbers are decoded as json.Number by ExecBytes
+		var got interface{}
+		buf, err := json.Marshal(res)
+		ok(t, err)
+		ok(t, json.Unmarshal(buf, &got))
+
+		equals(t, test.data.expected, got)
+	}
+}
+
+func TestExecBytesErrors(t *testing.T) {
+	q := haddoque.MustCompile(`.id where .name == "a"`)
+
+	_, err := q.ExecBytes([]byte(`{"id": 1, "name": "a"`))
+	var jerr *haddoque.InvalidJSONError
+	assert(t, errors.As(err, &jerr), "expected an InvalidJSONError, got %v", err)
+	equals(t, 21, jerr.Offset)
+
+	_, err = q.ExecBytes([]byte(`{"name": "a"}`))
+	equals(t, true, errors.Is(err, haddoque.ErrNonExistingFields))
+
+	_, err = q.ExecBytes([]byte(`"foo"`))
+	equals(t, haddoque.ErrInvalidObject, err)
+
+	res, err := q.ExecBytes([]byte(`{"id": 9007199254740993, "name": "b"}`))
+	ok(t, err)
+	equals(t, nil, res)
+
+	res, err = q.ExecBytes([]byte(`{"id": 9007199254740993, "name": "a", "other": {"big": [1, 2, 3]}}`))
+	ok(t, err)
+	equals(t, map[string]interface{}{"id": json.Number("9007199254740993")}, res)
+}
+
+// resultsTest is a query executed on all the objects of an input, whose results are expected encoded in JSON.
+type resultsTest struct {
+	query    string
+	expected string
+}
+
+// readInput reads a file of newline-delimited objects in testdata.
+func readInput(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	ok(t, err)
+
+	return string(data)
+}
+
+func decodeAll(t *testing.T, input string) []interface{} {
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+
+	var res []interface{}
+	for dec.More() {
+		var obj interface{}
+		ok(t, dec.Decode(&obj))
+		res = append(res, obj)
+	}
+
+	return res
+}
+
+// execAll executes exec on each object of the input and returns the results of the matching ones.
+func execAll(t *testing.T, exec func(obj interface{}) (interface{}, bool, error), input string) []interface{} {
+	res := []interface{}{}
+	for _, obj := range decodeAll(t, input) {
+		v, matched, err := exec(obj)
+		ok(t, err)
+
+		if matched {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// queryResults executes the query on all the objects of the input, with an Aggregator, a Collector
+// or a Deduplicator depending on its clauses, and returns the results encoded in JSON.
+func queryResults(t *testing.T, query, input string) string {
+	q := haddoque.MustCompile(query)
+
+	var res []interface{}
+	switch {
+	case q.IsAggregate():
+		agg, err := haddoque.NewAggregator(q)
+		ok(t, err)
+		for _, obj := range decodeAll(t, input) {
+			ok(t, agg.Add(obj))
+		}
+		res = agg.Results()
+	case q.IsCollection():
+		c, err := haddoque.NewCollector(q)
+		ok(t, err)
+		for _, obj := range decodeAll(t, input) {
+			ok(t, c.Add(obj))
+		}
+		res = c.Results()
+	case q.IsDistinct():
+		d, err := haddoque.NewDeduplicator(q, 0, 0)
+		ok(t, err)
+		res = execAll(t, d.Exec, input)
+	default:
+		res = execAll(t, q.ExecMatch, input)
+	}
+
+	return encodeJSON(t, res)
+}
+
+func encodeJSON(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	ok(t, err)
+
+	return string(data)
+}
+
+var benchmarkDocument = []byte(`{
+	"id": 1,
+	"type": "click",
+	"user": {"id": 12, "name": "Vincent", "roles": ["admin", "dev"], "locale": {"language": "fr", "region": "FR"}},
+	"device": {"os": "android", "version": 9, "screen": {"width": 1080, "height": 1920}},
+	"items": [{"sku": "a", "price": 10}, {"sku": "b", "price": 200}, {"sku": "c", "price": 30}],
+	"context": {"page": "/home", "referrer": "https://example.com/some/long/path?with=query&params=1", "tags": ["a", "b", "c", "d"]}
+}`)
+
+var benchmarkQuery = haddoque.MustCompile(`.id where .type == "purchase" and .user.id == 12`)
+
+func BenchmarkExecUnmarshal(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(benchmarkDocument, &obj); err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := benchmarkQuery.Exec(obj); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExecBytes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := benchmarkQuery.ExecBytes(benchmarkDocument); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, test := range tests {
+		readTest(t, test.file, &test.data.input, &test.data.query, &test.data.expected)
+
+		q, err := haddoque.Compile(test.data.query)
+		ok(t, err)
+		equals(t, test.data.query, q.String())
+
+		// executing twice must not alter the compiled query
+		for i := 0; i < 2; i++ {
+			res, err := q.Exec(test.data.input)
+			ok(t, err)
+			equals(t, test.data.expected, res)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	_, err := haddoque.Compile(`. where (.id == 1, .name)`)
+	assert(t, err != nil, "expected an error")
+}
+
+func TestCompileMalformedNoGoroutineLeak(t *testing.T) {
+	queries := []string{
+		`. where (.id == 1, .name)`,
+		`. where .name = "foobar"`,
+		`. where .name == "foobar`,
+		`. where .id in [1, where]`,
+		`. where .id in [1, 2`,
+		`.name $ .id`,
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5000; i++ {
+		_, err := haddoque.Compile(queries[i%len(queries)])
+		assert(t, err != nil, "expected an error for query %q", queries[i%len(queries)])
+	}
+	runtime.GC()
+
+	after := runtime.NumGoroutine()
+	assert(t, after <= before, "goroutines leaked: %d before, %d after", before, after)
+}
+
+func TestMustCompile(t *testing.T) {
+	defer func() {
+		assert(t, recover() != nil, "expected MustCompile to panic")
+	}()
+
+	haddoque.MustCompile(`. where (.id == 1, .name)`)
+}
+
+func TestQueryTree(t *testing.T) {
+	exp := "listNode\n chainNode{.id}\n whereNode\n  operationNode{tokGt}\n   chainNode{.age}\n   nodeNumber{int: 20}\n"
+	equals(t, exp, haddoque.MustCompile(`.id where .age > 20`).Tree())
+}
+
+func TestQueryMatch(t *testing.T) {
+	q := haddoque.MustCompile(`.id where (.name == "Vincent") and (.age > 20)`)
+
+	equals(t, true, q.Match(map[string]interface{}{"id": int64(1), "name": "Vincent", "age": int64(30)}))
+	equals(t, false, q.Match(map[string]interface{}{"id": int64(1), "name": "Vincent", "age": int64(10)}))
+	equals(t, false, q.Match(map[string]interface{}{"name": "Vincent", "age": int64(30)}))
+}
+
+func TestQueryExecMatch(t *testing.T) {
+	q := haddoque.MustCompile(`.id where .age > 20`, haddoque.WithMissingFields(haddoque.MissingOmit))
+
+	res, matched, err := q.ExecMatch(map[string]interface{}{"id": int64(1), "age": int64(30)})
+	ok(t, err)
+	equals(t, true, matched)
+	equals(t, map[string]interface{}{"id": int64(1)}, res)
+
+	_, matched, err = q.ExecMatch(map[string]interface{}{"id": int64(1), "age": int64(10)})
+	ok(t, err)
+	equals(t, false, matched)
+
+	// a matching object with no result
+	res, matched, err = q.ExecMatch(map[string]interface{}{"age": int64(30)})
+	ok(t, err)
+	equals(t, true, matched)
+	equals(t, nil, res)
+
+	_, _, err = q.ExecMatch(10)
+	equals(t, haddoque.ErrInvalidObject, err)
+}
+
+func TestQueryConcurrentExec(t *testing.T) {
+	q := haddoque.MustCompile(`.id, .name where (.id >= 50)`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := int64(0); j < 100; j++ {
+				res, err := q.Exec(map[string]interface{}{"id": j, "name": "foobar", "other": true})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if (res != nil) != (j >= 50) {
+					t.Errorf("unexpected result %v for id %d", res, j)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type matchTest struct {
+	query string
+	input map[string]interface{}
+	match bool
+}
+
+var quantifierInput = map[string]interface{}{
+	"id": int64(1),
+	"items": []interface{}{
+		map[string]interface{}{"sku": "a", "price": int64(10), "qty": int64(1)},
+		map[string]interface{}{"sku": "b", "price": int64(200), "qty": int64(0)},
+	},
+	"empty": []interface{}{},
+	"name":  "foobar",
+}
+
+var quantifierTests = []matchTest{
+	{`. where any(.items, .price > 100)`, quantifierInput, true},
+	{`. where any(.items, .price > 1000)`, quantifierInput, false},
+	{`. where all(.items, .price > 1)`, quantifierInput, true},
+	{`. where all(.items, .qty > 0)`, quantifierInput, false},
+	{`. where none(.items, .sku == "c")`, quantifierInput, true},
+	{`. where none(.items, .sku == "b")`, quantifierInput, false},
+	{`. where any(.empty, .price > 1)`, quantifierInput, false},
+	{`. where all(.empty, .price > 1)`, quantifierInput, true},
+	{`. where none(.empty, .price > 1)`, quantifierInput, true},
+	{`. where all(.name, .price > 1)`, quantifierInput, false},
+	{`. where all(.missing, .price > 1)`, quantifierInput, false},
+	{`. where any(.items[1:], .sku == "b")`, quantifierInput, true},
+	{`. where any(.items[*].sku, . == "a")`, quantifierInput, true},
+	{`. where (.id == 1) and any(.items, (.price > 100) and (.qty == 0))`, quantifierInput, true},
+}
+
+func TestQuantifiers(t *testing.T) {
+	for _, test := range quantifierTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var notInput = map[string]interface{}{
+	"id":   int64(1),
+	"type": "c",
+	"tags": []interface{}{"a", "b"},
+}
+
+var notTests = []matchTest{
+	{`. where not (.type == "c")`, notInput, false},
+	{`. where !(.type == "c")`, notInput, false},
+	{`. where not (.type in ["a", "b"])`, notInput, true},
+	{`. where not not (.type == "c")`, notInput, true},
+	{`. where !!(.type == "c")`, notInput, true},
+	{`. where not any(.tags, . == "c")`, notInput, true},
+	{`. where (.id == 1) and not (.type == "a")`, notInput, true},
+	{`. where not ((.id == 1) or (.type == "a"))`, notInput, false},
+}
+
+func TestNot(t *testing.T) {
+	for _, test := range notTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var nullInput = map[string]interface{}{
+	"id":        int64(1),
+	"name":      "foobar",
+	"deletedAt": nil,
+	"tags":      []interface{}{"a", nil},
+}
+
+var nullTests = []matchTest{
+	{`. where .deletedAt is null`, nullInput, true},
+	{`. where .deletedAt is not null`, nullInput, false},
+	{`. where .missing is null`, nullInput, true},
+	{`. where .missing is not null`, nullInput, false},
+	{`. where .name is null`, nullInput, false},
+	{`. where .name is not null`, nullInput, true},
+	{`. where .deletedAt == null`, nullInput, true},
+	{`. where .deletedAt != null`, nullInput, false},
+	{`. where .name == null`, nullInput, false},
+	{`. where .name != null`, nullInput, true},
+	{`. where .missing == null`, nullInput, false},
+	{`. where .deletedAt < 1`, nullInput, false},
+	{`. where .deletedAt in [null, 1]`, nullInput, true},
+	{`. where .tags contains null`, nullInput, true},
+	{`. where exists(.deletedAt)`, nullInput, true},
+	{`. where exists(.missing)`, nullInput, false},
+	{`. where exists(.tags[1])`, nullInput, true},
+	{`. where exists(.tags[2])`, nullInput, false},
+	{`. where not exists(.missing) and .id == 1`, nullInput, true},
+	{`. where any(.tags, . is null)`, nullInput, true},
+}
+
+func TestNull(t *testing.T) {
+	for _, test := range nullTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+type missingFieldsTest struct {
+	query    string
+	mode     haddoque.MissingFields
+	expected interface{}
+	missing  []string
+}
+
+var missingFieldsInput = map[string]interface{}{
+	"id": int64(1),
+	"device": map[string]interface{}{
+		"type": "mobile",
+	},
+	"items": []interface{}{},
+}
+
+var missingFieldsTests = []missingFieldsTest{
+	{`.id, .device.os, .name`, haddoque.MissingError, nil, []string{".device.os", ".name"}},
+	{`.id, .device.os?, .name?`, haddoque.MissingError, map[string]interface{}{"id": int64(1)}, nil},
+	{`.id, .device.os?, .name`, haddoque.MissingError, nil, []string{".name"}},
+	{`.id, .device.os, .name`, haddoque.MissingOmit, map[string]interface{}{"id": int64(1)}, nil},
+	{`.id, .device.os, .name?`, haddoque.MissingAsNull, map[string]interface{}{
+		"id":     int64(1),
+		"device": map[string]interface{}{"os": nil},
+		"name":   nil,
+	}, nil},
+	{`.id, .items[0].sku`, haddoque.MissingAsNull, map[string]interface{}{
+		"id":    int64(1),
+		"items": []interface{}{map[string]interface{}{"sku": nil}},
+	}, nil},
+	{`.id, .items[-1].sku`, haddoque.MissingAsNull, map[string]interface{}{"id": int64(1)}, nil},
+	{`.id, .tags[*].name`, haddoque.MissingAsNull, map[string]interface{}{"id": int64(1), "tags": nil}, nil},
+	{`.id, .items[*].sku`, haddoque.MissingError, map[string]interface{}{"id": int64(1)}, nil},
+}
+
+type execTest struct {
+	query    string
+	expected interface{}
+}
+
+var overlapInput = map[string]interface{}{
+	"items": []interface{}{
+		map[string]interface{}{"sku": "a", "qty": int64(1)},
+		map[string]interface{}{"sku": "b", "qty": int64(2)},
+	},
+	"tags": []interface{}{"t1", "t2"},
+	"user": map[string]interface{}{"name": "a", "roles": []interface{}{"r1", "r2"}},
+}
+
+var overlapTests = []execTest{
+	{`.items, .items[0].sku`, map[string]interface{}{"items": overlapInput["items"]}},
+	{`.items[0].sku, .items`, map[string]interface{}{"items": overlapInput["items"]}},
+	{`.tags, .tags[0]`, map[string]interface{}{"tags": overlapInput["tags"]}},
+	{`.tags[0], .tags`, map[string]interface{}{"tags": overlapInput["tags"]}},
+	{`.user, .user.roles[1]`, map[string]interface{}{"user": overlapInput["user"]}},
+	{`.items[1], .items[*].sku`, map[string]interface{}{"items": []interface{}{
+		map[string]interface{}{"sku": "a"},
+		map[string]interface{}{"sku": "b", "qty": int64(2)},
+	}}},
+}
+
+// TestOverlappingProjections checks that a field selected as a whole isn't reduced by another selector.
+func TestOverlappingProjections(t *testing.T) {
+	for _, test := range overlapTests {
+		res, err := haddoque.Exec(test.query, overlapInput)
+		ok(t, err)
+		equals(t, test.expected, res)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	for _, test := range missingFieldsTests {
+		q, err := haddoque.Compile(test.query, haddoque.WithMissingFields(test.mode))
+		ok(t, err)
+
+		res, err := q.Exec(missingFieldsInput)
+		if test.missing != nil {
+			merr, isMissing := err.(*haddoque.MissingFieldsError)
+			assert(t, isMissing, "expected a *MissingFieldsError for %q, got %v", test.query, err)
+			equals(t, test.missing, merr.Paths)
+			assert(t, errors.Is(err, haddoque.ErrNonExistingFields), "expected error to be ErrNonExistingFields")
+			equals(t, false, q.Match(missingFieldsInput))
+			continue
+		}
+
+		ok(t, err)
+		equals(t, test.expected, res)
+		equals(t, true, q.Match(missingFieldsInput))
+	}
+}
+
+var patternInput = map[string]interface{}{
+	"id":   int64(1),
+	"path": "/api/v2/users",
+	"host": "www.example.com",
+	"file": "report[1].csv",
+}
+
+var patternTests = []matchTest{
+	{`. where .path matches "^/api/v[0-9]+/"`, patternInput, true},
+	{`. where .path matches "users"`, patternInput, true},
+	{`. where .path matches "^users"`, patternInput, false},
+	{`. where .id matches "1"`, patternInput, false},
+	{`. where .missing matches ".*"`, patternInput, false},
+	{`. where .host like "*.example.com"`, patternInput, true},
+	{`. where .host like "example.com"`, patternInput, false},
+	{`. where .host like "www.example.co?"`, patternInput, true},
+	{`. where .host like "www?example?com"`, patternInput, true},
+	{`. where .host like "WWW.*"`, patternInput, false},
+	{`. where .file like "report[1].csv"`, patternInput, true},
+	{`. where .file like "report\\*"`, patternInput, false},
+	{`. where not .host like "*.example.org"`, patternInput, true},
+}
+
+func TestPatterns(t *testing.T) {
+	for _, test := range patternTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var functionInput = map[string]interface{}{
+	"id":   int64(1),
+	"name": "Vincent",
+	"path": "/api/v2/users",
+	"tags": []interface{}{"a", "b"},
+}
+
+var functionTests = []matchTest{
+	{`. where lower(.name) == "vincent"`, functionInput, true},
+	{`. where starts_with(.path, "/api")`, functionInput, true},
+	{`. where ends_with(.path, "/api")`, functionInput, false},
+	{`. where not contains_str(.path, "v3")`, functionInput, true},
+	{`. where len(.tags) == 2 and len(.name) > 5`, functionInput, true},
+	{`. where concat(.name, "-", substr(.path, 1, 3)) == "Vincent-api"`, functionInput, true},
+	{`. where substr(.name, 1, 9223372036854775807) == "incent"`, functionInput, true},
+	{`. where replace(.path, "v2", "v3") matches "v3"`, functionInput, true},
+	{`. where upper(.missing) == "FOO"`, functionInput, false},
+	{`. where upper(.id) == "1"`, functionInput, false},
+	{`. where starts_with(.id, "1")`, functionInput, false},
+	{`. where split(.path, "/") contains "users"`, functionInput, true},
+}
+
+func TestFunctions(t *testing.T) {
+	for _, test := range functionTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+func TestComputedFields(t *testing.T) {
+	q := haddoque.MustCompile(`., len(.tags), upper(.missing)`)
+	res, err := q.Exec(functionInput)
+	ok(t, err)
+	equals(t, map[string]interface{}{
+		"id":         int64(1),
+		"name":       "Vincent",
+		"path":       "/api/v2/users",
+		"tags":       []interface{}{"a", "b"},
+		"len(.tags)": int64(2),
+	}, res)
+
+	q = haddoque.MustCompile(`.id, upper(.missing)`, haddoque.WithMissingFields(haddoque.MissingAsNull))
+	res, err = q.Exec(functionInput)
+	ok(t, err)
+	equals(t, map[string]interface{}{"id": int64(1), "upper(.missing)": nil}, res)
+}
+
+func TestAliases(t *testing.T) {
+	testCases := []struct {
+		query    string
+		mode     haddoque.MissingFields
+		expected interface{}
+	}{
+		{`.name as first, len(.tags) as n_tags`, haddoque.MissingError, map[string]interface{}{"first": "Vincent", "n_tags": int64(2)}},
+		{`.id, .id * 10, .id*-1`, haddoque.MissingError, map[string]interface{}{"id": int64(1), ".id * 10": int64(10), ".id*-1": int64(-1)}},
+		{`.id, len(.tags) * 2 + 1 as n`, haddoque.MissingError, map[string]interface{}{"id": int64(1), "n": int64(5)}},
+		{`. as doc, .id as "the id"`, haddoque.MissingError, map[string]interface{}{"doc": functionInput, "the id": int64(1)}},
+		{`.tags[0] as tags.first, .tags[-1] as tags.last`, haddoque.MissingError, map[string]interface{}{"tags": map[string]interface{}{"first": "a", "last": "b"}}},
+		{`.id, .missing? as out.value`, haddoque.MissingError, map[string]interface{}{"id": int64(1)}},
+		{`.id, .missing as out.value, .missing * 2 as n`, haddoque.MissingAsNull, map[string]interface{}{"id": int64(1), "out": map[string]interface{}{"value": nil}, "n": nil}},
+		{`.id, .missing as value, .name + 1 as n`, haddoque.MissingOmit, map[string]interface{}{"id": int64(1)}},
+	}
+
+	for _, tc := range testCases {
+		q, err := haddoque.Compile(tc.query, haddoque.WithMissingFields(tc.mode))
+		ok(t, err)
+
+		res, err := q.Exec(functionInput)
+		ok(t, err)
+		equals(t, tc.expected, res)
+	}
+
+	// renamed fields are still checked for missing fields
+	_, err := haddoque.MustCompile(`.id, .missing as value`).Exec(functionInput)
+	var merr *haddoque.MissingFieldsError
+	assert(t, errors.As(err, &merr), "expected a *MissingFieldsError, got %v", err)
+	equals(t, []string{".missing"}, merr.Paths)
+
+	// only the fields used are decoded
+	res, err := haddoque.MustCompile(`.user.name as name, .a * .b as product`).ExecBytes([]byte(`{"a": 2, "b": 3, "c": [1], "user": {"name": "foo", "id": 1}}`))
+	ok(t, err)
+	equals(t, map[string]interface{}{"name": "foo", "product": int64(6)}, res)
+}
+
+var aliasCompileErrorTests = []compileErrorTest{
+	{`.id as`, `unexpected end of query`},
+	{`.id as 1`, `unexpected "1"`},
+	{`.id as where`, `unexpected "where"`},
+	{`.id as out., .name`, `unexpected "."`},
+	{`.id as a, .name as a`, `alias a conflicts with a`},
+	{`.id as out.id, .name as out`, `alias out conflicts with out.id`},
+	{`.id + 1 == 2`, `unexpected "=="`},
+	{`select select .id`, `unexpected "select"`},
+	{`.id order by total`, `unknown alias total`},
+	{`.id as out.id order by out`, `unknown alias out`},
+}
+
+func TestAliasCompileErrors(t *testing.T) {
+	testCompileErrors(t, aliasCompileErrorTests)
+}
+
+var arithInput = map[string]interface{}{
+	"price": 12.5,
+	"qty":   int64(100),
+	"start": int64(1000),
+	"end":   int64(1075),
+	"zero":  int64(0),
+	"name":  "foo",
+}
+
+var arithTests = []matchTest{
+	{`. where .price * .qty == 1250`, arithInput, true},
+	{`. where .end - .start >= 60`, arithInput, true},
+	{`. where .end - .start - 10 == 65`, arithInput, true},
+	{`. where .end - (.start - 10) == 85`, arithInput, true},
+	{`. where .start + .qty * 2 == 1200`, arithInput, true},
+	{`. where (.start + .qty) * 2 == 2200`, arithInput, true},
+	{`. where .qty / 8 == 12`, arithInput, true},
+	{`. where .price / 2 == 6.25`, arithInput, true},
+	{`. where .qty % 7 == 2`, arithInput, true},
+	{`. where -.qty < 0`, arithInput, true},
+	{`. where .qty - -1 == 101`, arithInput, true},
+	{`. where .qty / .zero == 0 or .qty / .zero != 0`, arithInput, false},
+	{`. where .name + "bar" == "foobar"`, arithInput, true},
+	{`. where .name + 1 == "foo1"`, arithInput, false},
+	{`. where .missing + 1 is null`, arithInput, true},
+	{`. where len(.name) * 2 == 6`, arithInput, true},
+}
+
+func TestArithmetic(t *testing.T) {
+	for _, test := range arithTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var operandsInput = map[string]interface{}{
+	"billing":  map[string]interface{}{"country": "FR"},
+	"shipping": map[string]interface{}{"country": "FR"},
+	"other":    map[string]interface{}{"country": "DE"},
+	"roles":    []interface{}{"admin", "dev"},
+	"count":    int64(12),
+	"limit":    12.0,
+	"tags":     []interface{}{"a", "b", "c"},
+}
+
+var operandsTests = []matchTest{
+	{`. where .billing.country == .shipping.country`, operandsInput, true},
+	{`. where .billing.country == .other.country`, operandsInput, false},
+	{`. where .billing.country != .other.country`, operandsInput, true},
+	{`. where "admin" in .roles`, operandsInput, true},
+	{`. where "root" in .roles`, operandsInput, false},
+	{`. where .billing.country in .roles`, operandsInput, false},
+	{`. where "admin" in .billing`, operandsInput, false},
+	{`. where 10 < .count`, operandsInput, true},
+	{`. where 12 >= .count`, operandsInput, true},
+	{`. where 13 <= .count`, operandsInput, false},
+	{`. where .count >= .limit and .count <= .limit`, operandsInput, true},
+	{`. where .count >= 13`, operandsInput, false},
+	{`. where "FR" == .billing.country`, operandsInput, true},
+	{`. where 1 == 1`, operandsInput, true},
+	{`. where .count * 2 == .limit + 12`, operandsInput, true},
+	{`. where ["a", "b"] contains "a"`, operandsInput, true},
+	{`. where .tags contains ["a", "c"]`, operandsInput, true},
+	{`. where .tags contains ["a", "d"]`, operandsInput, false},
+	{`. where .roles contains .billing.country`, operandsInput, false},
+	{`. where .missing contains "a"`, operandsInput, false},
+}
+
+func TestOperands(t *testing.T) {
+	for _, test := range operandsTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+func TestNumberKinds(t *testing.T) {
+	input := map[string]interface{}{
+		"int":    int(1),
+		"int8":   int8(1),
+		"int32":  int32(1),
+		"uint":   uint(1),
+		"uint64": uint64(1),
+		"float":  float32(1),
+	}
+
+	for key := range input {
+		q := haddoque.MustCompile(`. where ` + "." + key + ` == 1 and .` + key + ` < 1.5 and .` + key + ` + 1 == 2`)
+		assert(t, q.Match(input), "expected %s to match", key)
+	}
+
+	q := haddoque.MustCompile(`. where .id == 18446744073709551615`)
+	assert(t, q.Match(map[string]interface{}{"id": uint64(math.MaxUint64)}), "expected the largest uint64 to match")
+	assert(t, !q.Match(map[string]interface{}{"id": float64(math.MaxUint64)}), "expected a float64 not to match")
+}
+
+func TestJSONNumbers(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"id": 9007199254740993, "price": 12.5, "qty": 3, "tags": [1, 2], "big": 18446744073709551615, "neg": -9223372036854775809}`))
+	dec.UseNumber()
+
+	var input map[string]interface{}
+	ok(t, dec.Decode(&input))
+
+	for _, test := range []struct {
+		query string
+		match bool
+	}{
+		{`. where .id == 9007199254740993`, true},
+		{`. where .id == 9007199254740992`, false},
+		{`. where .id > 9007199254740992`, true},
+		{`. where .price == 12.5 and .qty >= 3`, true},
+		{`. where .price * .qty == 37.5`, true},
+		{`. where 2 in .tags`, true},
+		{`. where substr("abcdef", .qty) == "def"`, true},
+		{`. where .big == 18446744073709551615`, true},
+		{`. where .big < 18446744073709551616 and .big > 18446744073709551614`, true},
+		{`. where .big in [100000000000000000000, 18446744073709551615]`, true},
+		{`. where .neg == -9223372036854775809 and .neg < -9223372036854775808`, true},
+		{`. where .id < 18446744073709551615`, true},
+		{`. where .qty * 1e3 == 3e+3 and .price >= 1.25E1`, true},
+		{`. where .price == 2.5E-1`, false},
+		{`. where -1e3 < .neg`, false},
+	} {
+		q := haddoque.MustCompile(test.query)
+		assert(t, test.match == q.Match(input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var equalityInput = map[string]interface{}{
+	"active":  true,
+	"deleted": false,
+	"tags":    []interface{}{"a", "b"},
+	"geo":     map[string]interface{}{"lat": 1.0, "lon": int64(2)},
+	"nested":  []interface{}{map[string]interface{}{"id": 1.0, "ok": true}, nil},
+	"id":      int64(1),
+}
+
+var equalityTests = []matchTest{
+	{`. where .active == true`, equalityInput, true},
+	{`. where .active == false`, equalityInput, false},
+	{`. where .deleted != true`, equalityInput, true},
+	{`. where .active == 1`, equalityInput, false},
+	{`. where .active != 1`, equalityInput, true},
+	{`. where .id != "1"`, equalityInput, true},
+	{`. where true in [false, true]`, equalityInput, true},
+	{`. where .tags == ["a", "b"]`, equalityInput, true},
+	{`. where .tags == ["b", "a"]`, equalityInput, false},
+	{`. where .tags == ["a"]`, equalityInput, false},
+	{`. where .tags != ["a"]`, equalityInput, true},
+	{`. where .geo == {"lat": 1, "lon": 2}`, equalityInput, true},
+	{`. where .geo == {"lon": 2.0, "lat": 1}`, equalityInput, true},
+	{`. where .geo == {"lat": 1}`, equalityInput, false},
+	{`. where .geo == {"lat": 1, "lon": 3}`, equalityInput, false},
+	{`. where .geo == {"lat": 1, "lon": 2, "alt": 0}`, equalityInput, false},
+	{`. where .geo != {"lat": 1}`, equalityInput, true},
+	{`. where .nested == [{"id": 1, "ok": true}, null]`, equalityInput, true},
+	{`. where .nested[0] in [{"id": 1, "ok": true}]`, equalityInput, true},
+	{`. where .nested contains {"id": 1, "ok": true}`, equalityInput, true},
+	{`. where .nested contains [null]`, equalityInput, true},
+	{`. where .tags == .tags`, equalityInput, true},
+	{`. where {} == {}`, equalityInput, true},
+	{`. where [] != [null]`, equalityInput, true},
+	{`. where .geo < {"lat": 2}`, equalityInput, false},
+}
+
+func TestEquality(t *testing.T) {
+	for _, test := range equalityTests {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+}
+
+var elementsInput = map[string]interface{}{
+	"country":  "FR",
+	"billing":  map[string]interface{}{"country": "DE"},
+	"shipping": map[string]interface{}{"country": "FR"},
+	"tags":     []interface{}{"a", "b"},
+	"n":        2.0,
+}
+
+func TestListElements(t *testing.T) {
+	for _, test := range []matchTest{
+		{`. where .country in [.billing.country, .shipping.country]`, elementsInput, true},
+		{`. where .country in [.billing.country, .other.country]`, elementsInput, false},
+		{`. where "DE" in [.other.country, .billing.country]`, elementsInput, true},
+		{`. where .tags == [.missing, "a", .tags[1]]`, elementsInput, true},
+		{`. where .tags contains [lower("A"), .missing]`, elementsInput, true},
+		{`. where [.n * 2, .n + 1] == [4, 3]`, elementsInput, true},
+		{`. where {"c": .country, "d": .missing} == {"c": "FR"}`, elementsInput, true},
+		{`. where [.missing] == []`, elementsInput, true},
+	} {
+		q, err := haddoque.Compile(test.query)
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+
+	res, err := haddoque.Exec(`len([.country, .missing, .n]) as n`, elementsInput)
+	ok(t, err)
+	equals(t, map[string]interface{}{"n": int64(2)}, res)
+
+	for query, msg := range map[string]string{
+		`. where .id in [(.a == 1)]`:        `list element must be a value`,
+		`. where .a == {"b": (.b != null)}`: `object member must be a value`,
+		`. where .id in [.a 1]`:             `unexpected "1"`,
+	} {
+		_, err := haddoque.Compile(query)
+
+		var serr *haddoque.SyntaxError
+		assert(t, errors.As(err, &serr), "%s: expected a syntax error, got %v", query, err)
+		equals(t, msg, serr.Msg)
+	}
+}
+
+type device struct {
+	OS      string `json:"os"`
+	Version int    `json:"version"`
+}
+
+type user struct {
+	ID      uint64            `json:"id"`
+	Name    string            `json:"name"`
+	Roles   []string          `json:"roles"`
+	Device  *device           `json:"device,omitempty"`
+	Scores  map[string]int    `json:"scores"`
+	Ignored string            `json:"-"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func TestStructInput(t *testing.T) {
+	u := &user{
+		ID:     18446744073709551615,
+		Name:   "Vincent",
+		Roles:  []string{"admin", "dev"},
+		Device: &device{OS: "android", Version: 9},
+		Scores: map[string]int{"a": 1},
+	}
+
+	q := haddoque.MustCompile(`.name, .device.os, .scores where "admin" in .roles and .device.version >= 9 and .id > 9223372036854775807`)
+
+	res, err := q.Exec(u)
+	ok(t, err)
+	equals(t, map[string]interface{}{
+		"name":   "Vincent",
+		"device": map[string]interface{}{"os": "android"},
+		"scores": map[string]interface{}{"a": int64(1)},
+	}, res)
+
+	res, err = q.Exec(*u)
+	ok(t, err)
+	assert(t, res != nil, "expected a struct value to be accepted")
+
+	assert(t, !haddoque.MustCompile(`. where .Ignored == ""`).Match(u), "expected the ignored field to be absent")
+	assert(t, haddoque.MustCompile(`. where not exists(.labels)`).Match(u), "expected the empty field to be omitted")
+
+	_, err = haddoque.MustCompile(`.device.os`).Exec(&user{})
+	equals(t, true, errors.Is(err, haddoque.ErrNonExistingFields))
+}
+
+func TestGoValuesInput(t *testing.T) {
+	res, err := haddoque.Exec(`.[1].os where .[0].version == 1`, []device{{"linux", 1}, {"ios", 2}})
+	ok(t, err)
+	equals(t, []interface{}{map[string]interface{}{"os": "ios"}}, res)
+
+	assert(t, haddoque.MustCompile(`. where .a + .b == 3`).Match(map[string]int{"a": 1, "b": 2}), "expected a typed map to match")
+
+	for _, obj := range []interface{}{nil, 1, "foo", (*user)(nil)} {
+		_, err := haddoque.Exec(`.`, obj)
+		equals(t, haddoque.ErrInvalidObject, err)
+	}
+}
+
+type country string
+
+type flag bool
+
+var customFuncs = haddoque.FuncMap{
+	"is_eu": func(country string) bool {
+		switch country {
+		case "FR", "DE", "IT":
+			return true
+		}
+		return false
+	},
+	"tenant": func(id int) (string, error) {
+		if id <= 0 {
+			return "", errors.New("invalid tenant id")
+		}
+		return fmt.Sprintf("tenant-%d", id), nil
+	},
+	"lower":  func(s string) string { return "overridden" },
+	"byte":   func(n uint8) uint8 { return n },
+	"id64":   func(n uint64) string { return strconv.FormatUint(n, 10) },
+	"single": func(f float32) float32 { return f * 2 },
+	"must": func(s string) string {
+		if s == "" {
+			panic("empty string")
+		}
+		return s
+	},
+	"country": func(s string) country { return country(s) },
+	"flag":    func(b bool) flag { return flag(b) },
+	"eu":      func(c country) bool { return c == "FR" || c == "DE" },
+	"counts":  func() map[string]int { return map[string]int{"a": 1} },
+	"device":  func(os string) *device { return &device{OS: os, Version: 1} },
+	"anyv":    func(v interface{}) interface{} { return v },
+	"nilptr": func(p *int) int {
+		return *p
+	},
+}
+
+var customFuncTests = []matchTest{
+	{`. where is_eu(.country)`, map[string]interface{}{"country": "FR"}, true},
+	{`. where is_eu(.country)`, map[string]interface{}{"country": "US"}, false},
+	{`. where is_eu(.country)`, map[string]interface{}{"country": 1.0}, false},
+	{`. where tenant(.id) == "tenant-12"`, map[string]interface{}{"id": 12.0}, true},
+	{`. where tenant(.id) == "tenant-12"`, map[string]interface{}{"id": 12.5}, false},
+	{`. where tenant(.id) is null`, map[string]interface{}{"id": -1.0}, true},
+	{`. where lower(.name) == "overridden"`, map[string]interface{}{"name": "A"}, true},
+	{`. where byte(.n) == 3`, map[string]interface{}{"n": 3.0}, true},
+	{`. where byte(.n) == 255`, map[string]interface{}{"n": json.Number("255")}, true},
+	{`. where byte(.n) is null`, map[string]interface{}{"n": -1.0}, true},
+	{`. where byte(.n) is null`, map[string]interface{}{"n": 256.0}, true},
+	{`. where byte(.n) is null`, map[string]interface{}{"n": 1.5}, true},
+	{`. where byte(3) == 3`, map[string]interface{}{}, true},
+	{`. where id64(.id) == "18446744073709551615"`, map[string]interface{}{"id": json.Number("18446744073709551615")}, true},
+	{`. where id64(.id) is null`, map[string]interface{}{"id": json.Number("18446744073709551616")}, true},
+	{`. where single(.x) == 1`, map[string]interface{}{"x": 0.5}, true},
+	{`. where single(.x) is null`, map[string]interface{}{"x": 0.1}, true},
+	{`. where single(.x) is null`, map[string]interface{}{"x": 1e300}, true},
+	{`. where single(.x) is null`, map[string]interface{}{"x": 16777217}, true},
+	{`. where must(.s) == "a"`, map[string]interface{}{"s": "a"}, true},
+	{`. where must(.s) is null`, map[string]interface{}{"s": ""}, true},
+	{`. where must(.s) == ""`, map[string]interface{}{"s": ""}, false},
+	{`. where country(.c) == "FR"`, map[string]interface{}{"c": "FR"}, true},
+	{`. where country(.c) in ["DE", "FR"]`, map[string]interface{}{"c": "FR"}, true},
+	{`. where flag(.ok)`, map[string]interface{}{"ok": true}, true},
+	{`. where flag(.ok)`, map[string]interface{}{"ok": false}, false},
+	{`. where not flag(.ok)`, map[string]interface{}{"ok": false}, true},
+	{`. where eu(.c)`, map[string]interface{}{"c": "FR"}, true},
+	{`. where eu(.c)`, map[string]interface{}{"c": "US"}, false},
+	{`. where eu("DE") and eu(country(.c))`, map[string]interface{}{"c": "FR"}, true},
+	{`. where counts() == {"a": 1}`, map[string]interface{}{}, true},
+	{`. where device(.os) == {"os": "ios", "version": 1}`, map[string]interface{}{"os": "ios"}, true},
+	{`. where upper(anyv(.name)) == "A"`, map[string]interface{}{"name": "a"}, true},
+	{`. where upper(anyv(.name)) is null`, map[string]interface{}{"name": 1.0}, true},
+	{`. where anyv(country(.c)) == "FR"`, map[string]interface{}{"c": "FR"}, true},
+}
+
+func TestCustomFuncs(t *testing.T) {
+	for _, test := range customFuncTests {
+		q, err := haddoque.Compile(test.query, haddoque.WithFuncs(customFuncs))
+		ok(t, err)
+		assert(t, test.match == q.Match(test.input), "expected match to be %v for %q", test.match, test.query)
+	}
+
+	// a function which panics doesn't stop a stream
+	var buf bytes.Buffer
+	q := haddoque.MustCompile(`must(.s) as s`, haddoque.WithFuncs(customFuncs), haddoque.WithMissingFields(haddoque.MissingAsNull))
+	ok(t, haddoque.Filter(strings.NewReader(`{"s": ""} {"s": "a"}`), &buf, q))
+	equals(t, "{\"s\":null}\n{\"s\":\"a\"}\n", buf.String())
+
+	// a runtime error is a bug of the function, which isn't hidden
+	func() {
+		defer func() {
+			_, isRuntimeErr := recover().(runtime.Error)
+			assert(t, isRuntimeErr, "expected the runtime error of the function to be propagated")
+		}()
+		haddoque.MustCompile(`. where nilptr(null) == 1`, haddoque.WithFuncs(customFuncs)).Match(map[string]interface{}{})
+	}()
+
+	_, err := haddoque.Compile(`. where is_eu(.country)`)
+	assert(t, err != nil, "expected an error for an unregistered function")
+}
+
+func TestCustomFuncsCompileErrors(t *testing.T) {
+	for _, query := range []string{
+		`. where is_eu()`,
+		`. where is_eu("FR", "DE")`,
+		`. where is_eu(1)`,
+		`. where eu(1)`,
+		`. where eu(true)`,
+		`. where eu(flag(true))`,
+		`. where tenant("12") == "tenant-12"`,
+		`. where tenant(1.5) == "tenant-1"`,
+		`. where is_eu(len(.name))`,
+		`. where tenant(is_eu("FR")) == "a"`,
+		`. where byte(256) == 0`,
+		`. where byte(1.5) == 1`,
+		`. where single(0.1) == 0.2`,
+	} {
+		_, err := haddoque.Compile(query, haddoque.WithFuncs(customFuncs))
+		var serr *haddoque.SyntaxError
+		assert(t, errors.As(err, &serr), "expected a syntax error for %q, got %v", query, err)
+	}
+}
+
+func TestWithFuncsInvalid(t *testing.T) {
+	for _, funcs := range []haddoque.FuncMap{
+		{"foo": "not a function"},
+		{"foo": func() {}},
+		{"foo": func() (int, int) { return 0, 0 }},
+		{"foo-bar": func() int { return 0 }},
+		{"where": func() int { return 0 }},
+		{"": func() int { return 0 }},
+	} {
+		func() {
+			defer func() {
+				assert(t, recover() != nil, "expected WithFuncs to panic for %v", funcs)
+			}()
+			haddoque.WithFuncs(funcs)
+		}()
+	}
+}
+
 // assert fails the test if the condition is false.
 func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
 	if !condition {
@@ -16,7 +16,7 @@ const (
 	tokWhitespace
 
 	tokField      // alphanumeric identifier starting with .
-	tokIdentifier // alphanumeric identifier not starting with . (unused for now)
+	tokIdentifier // alphanumeric identifier not starting with ., the name of a function
 
 	// literals
 	tokLiteralsBegin
@@ -24,6 +24,7 @@ const (
 	tokChar   // character constant
 	tokString // quoted string
 	tokNumber // simple number
+	tokNull   // null constant
 	tokLiteralsEnd
 
 	// misc
@@ -31,7 +32,16 @@ const (
 	tokRparen   // )
 	tokLbracket // [
 	tokRbracket // ]
+	tokLbrace   // {
+	tokRbrace   // }
 	tokComma    // ,
+	tokColon    // :
+	tokStar     // *, also the multiplication operator
+	tokQuestion // ?
+	tokPlus     // +
+	tokMinus    // -
+	tokSlash    // /
+	tokPercent  // %
 
 	// keywords
 	tokKeywordsBegin
@@ -40,6 +50,24 @@ const (
 	tokOr
 	tokIn
 	tokContains
+	tokAny
+	tokAll
+	tokNone
+	tokIs
+	tokExists
+	tokMatches
+	tokLike
+	tokGroup
+	tokBy
+	tokOrder
+	tokAsc
+	tokDesc
+	tokLimit
+	tokOffset
+	tokDistinct
+	tokOn
+	tokSelect
+	tokAs
 	tokKeywordsEnd
 
 	// operators
@@ -50,7 +78,7 @@ const (
 	tokGte // >=
 	tokEq  // ==
 	tokNeq // !=
-	tokNot // !
+	tokNot // ! or not
 	tokOperatorsEnd
 )
 
@@ -68,20 +96,22 @@ func (l lexeme) String() string {
 
 type lexStateFn func(*lexer) lexStateFn
 
+// lexer is a pull-based tokenizer: each call to nextLexeme runs the state
+// functions until exactly one lexeme has been produced.
 type lexer struct {
-	err   error
-	input string
-	start int
-	pos   int
-	width int
-	state lexStateFn
-	items chan lexeme
+	input   string
+	start   int
+	pos     int
+	width   int
+	state   lexStateFn
+	item    lexeme
+	emitted bool
 }
 
 func newLexer(s string) *lexer {
 	return &lexer{
 		input: s,
-		items: make(chan lexeme),
+		state: lexText,
 	}
 }
 
@@ -134,27 +164,30 @@ func (l *lexer) acceptRun(valid string) {
 }
 
 func (l *lexer) emit(tok token) {
-	l.items <- lexeme{tok, l.start, l.input[l.start:l.pos]}
+	l.item = lexeme{tok, l.start, l.input[l.start:l.pos]}
+	l.emitted = true
 	l.start = l.pos
 }
 
 func (l *lexer) errorf(format string, args ...interface{}) lexStateFn {
-	l.items <- lexeme{tokError, l.start, fmt.Sprintf(format, args...)}
+	l.item = lexeme{tokError, l.start, fmt.Sprintf(format, args...)}
+	l.emitted = true
 	return nil
 }
 
-func (l *lexer) lex() {
-	go l.run()
-}
-
+// nextLexeme returns the next lexeme from the input.
+//
+// Once the input is exhausted or an error occurred, it keeps returning the last lexeme.
 func (l *lexer) nextLexeme() lexeme {
-	return <-l.items
-}
-
-func (l *lexer) run() {
-	for l.state = lexText; l.state != nil; {
+	l.emitted = false
+	for l.state != nil {
 		l.state = l.state(l)
+		if l.emitted {
+			return l.item
+		}
 	}
+
+	return l.item
 }
 
 func (l *lexer) atTerminator() bool {
@@ -192,11 +225,29 @@ func lexText(l *lexer) lexStateFn {
 		l.emit(tokLbracket)
 	case ch == ']':
 		l.emit(tokRbracket)
+	case ch == '{':
+		l.emit(tokLbrace)
+	case ch == '}':
+		l.emit(tokRbrace)
+	case ch == ':':
+		l.emit(tokColon)
+	case ch == '*':
+		l.emit(tokStar)
+	case ch == '?':
+		l.emit(tokQuestion)
+	case ch == '+':
+		l.emit(tokPlus)
+	case ch == '-':
+		l.emit(tokMinus)
+	case ch == '/':
+		l.emit(tokSlash)
+	case ch == '%':
+		l.emit(tokPercent)
 	case ch == '<':
 		return lexLt
 	case ch == '>':
 		return lexGt
-	case ch == '+', ch == '-', ('0' <= ch && ch <= '9'):
+	case '0' <= ch && ch <= '9':
 		return lexNumber
 	case ch == '"':
 		return lexString
@@ -229,6 +280,7 @@ func lexField(l *lexer) lexStateFn {
 func lexEq(l *lexer) lexStateFn {
 	ch := l.next()
 	if ch != '=' {
+		l.backup()
 		return l.errorf("expected = after =")
 	}
 
@@ -276,11 +328,18 @@ func lexGt(l *lexer) lexStateFn {
 func lexNumber(l *lexer) lexStateFn {
 	l.backup()
 
-	l.accept("+-")
 	l.acceptRun("0123456789")
 	if l.accept(".") {
 		l.acceptRun("0123456789")
 	}
+	if l.accept("eE") {
+		l.accept("+-")
+		if !l.accept("0123456789") {
+			l.next()
+			return l.errorf("bad number syntax: %q", l.input[l.start:l.pos])
+		}
+		l.acceptRun("0123456789")
+	}
 
 	if isAlphaNumeric(l.peek()) {
 		l.next()
@@ -296,6 +355,13 @@ func lexString(l *lexer) lexStateFn {
 loop:
 	for {
 		switch l.next() {
+		case '\\':
+			if ch := l.next(); ch != eof && ch != '\n' {
+				break
+			}
+			fallthrough
+		case eof, '\n':
+			return l.errorf("unterminated quoted string")
 		case '"':
 			break loop
 		}
@@ -327,8 +393,48 @@ loop:
 				l.emit(tokIn)
 			case word == "contains":
 				l.emit(tokContains)
+			case word == "any":
+				l.emit(tokAny)
+			case word == "all":
+				l.emit(tokAll)
+			case word == "none":
+				l.emit(tokNone)
+			case word == "not":
+				l.emit(tokNot)
+			case word == "is":
+				l.emit(tokIs)
+			case word == "exists":
+				l.emit(tokExists)
+			case word == "matches":
+				l.emit(tokMatches)
+			case word == "like":
+				l.emit(tokLike)
+			case word == "group":
+				l.emit(tokGroup)
+			case word == "by":
+				l.emit(tokBy)
+			case word == "order":
+				l.emit(tokOrder)
+			case word == "asc":
+				l.emit(tokAsc)
+			case word == "desc":
+				l.emit(tokDesc)
+			case word == "limit":
+				l.emit(tokLimit)
+			case word == "offset":
+				l.emit(tokOffset)
+			case word == "distinct":
+				l.emit(tokDistinct)
+			case word == "on":
+				l.emit(tokOn)
+			case word == "select":
+				l.emit(tokSelect)
+			case word == "as":
+				l.emit(tokAs)
 			case word == "true", word == "false":
 				l.emit(tokBool)
+			case word == "null":
+				l.emit(tokNull)
 			default:
 				l.emit(tokIdentifier)
 			}
@@ -351,5 +457,5 @@ func isWhitespace(ch rune) bool {
 }
 
 func isAlphaNumeric(ch rune) bool {
-	return unicode.IsLetter(ch) || unicode.IsDigit(ch)
+	return ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch)
 }
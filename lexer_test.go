@@ -29,6 +29,12 @@ var (
 	tRparen   = lexeme{tokRparen, 0, ")"}
 	tLbracket = lexeme{tokLbracket, 0, "["}
 	tRbracket = lexeme{tokRbracket, 0, "]"}
+	tColon    = lexeme{tokColon, 0, ":"}
+	tStar     = lexeme{tokStar, 0, "*"}
+	tPlus     = lexeme{tokPlus, 0, "+"}
+	tMinus    = lexeme{tokMinus, 0, "-"}
+	tSlash    = lexeme{tokSlash, 0, "/"}
+	tPercent  = lexeme{tokPercent, 0, "%"}
 )
 
 var lexTests = []lexTest{
@@ -130,11 +136,169 @@ var lexTests = []lexTest{
 		tRparen,
 		tEOF,
 	}},
+	{"with not keyword", `. where not (.age == 10)`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokNot, 0, "not"},
+		tLparen,
+		{tokField, 0, ".age"},
+		tEq,
+		{tokNumber, 0, "10"},
+		tRparen,
+		tEOF,
+	}},
+	{"with null", `. where .a is not null and exists(.b) and .c == null`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokField, 0, ".a"},
+		{tokIs, 0, "is"},
+		{tokNot, 0, "not"},
+		{tokNull, 0, "null"},
+		tAnd,
+		{tokExists, 0, "exists"},
+		tLparen,
+		{tokField, 0, ".b"},
+		tRparen,
+		tAnd,
+		{tokField, 0, ".c"},
+		tEq,
+		{tokNull, 0, "null"},
+		tEOF,
+	}},
+	{"array selectors", ".items[0].sku, .items[-1:], .items[*]", []lexeme{
+		{tokField, 0, ".items"},
+		tLbracket,
+		{tokNumber, 0, "0"},
+		tRbracket,
+		{tokField, 0, ".sku"},
+		tComma,
+		{tokField, 0, ".items"},
+		tLbracket,
+		tMinus,
+		{tokNumber, 0, "1"},
+		tColon,
+		tRbracket,
+		tComma,
+		{tokField, 0, ".items"},
+		tLbracket,
+		tStar,
+		tRbracket,
+		tEOF,
+	}},
+	{"exponents", ".a == 1e3 or .b < 2.5E-1", []lexeme{
+		{tokField, 0, ".a"},
+		tEq,
+		{tokNumber, 0, "1e3"},
+		tOr,
+		{tokField, 0, ".b"},
+		tLt,
+		{tokNumber, 0, "2.5E-1"},
+		tEOF,
+	}},
+	{"optional field", ".device.os?, .id", []lexeme{
+		{tokField, 0, ".device"},
+		{tokField, 0, ".os"},
+		{tokQuestion, 0, "?"},
+		tComma,
+		{tokField, 0, ".id"},
+		tEOF,
+	}},
+	{"unterminated string", `. where .name == "foobar`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokField, 0, ".name"},
+		tEq,
+		{tokError, 0, "unterminated quoted string"},
+	}},
+	{"escaped quote", `. where .name == "foo\"bar"`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokField, 0, ".name"},
+		tEq,
+		{tokString, 0, `"foo\"bar"`},
+		tEOF,
+	}},
+	{"arithmetic", `. where .price*.qty+1-.b/2.5 % -3 > 0`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokField, 0, ".price"},
+		tStar,
+		{tokField, 0, ".qty"},
+		tPlus,
+		{tokNumber, 0, "1"},
+		tMinus,
+		{tokField, 0, ".b"},
+		tSlash,
+		{tokNumber, 0, "2.5"},
+		tPercent,
+		tMinus,
+		{tokNumber, 0, "3"},
+		tGt,
+		{tokNumber, 0, "0"},
+		tEOF,
+	}},
+	{"object literal", `{"a": [1]}`, []lexeme{
+		{tokLbrace, 0, "{"},
+		{tokString, 0, `"a"`},
+		tColon,
+		tLbracket,
+		{tokNumber, 0, "1"},
+		tRbracket,
+		{tokRbrace, 0, "}"},
+		tEOF,
+	}},
+	{"group by", `count() group by .type`, []lexeme{
+		{tokIdentifier, 0, "count"},
+		tLparen,
+		tRparen,
+		{tokGroup, 0, "group"},
+		{tokBy, 0, "by"},
+		{tokField, 0, ".type"},
+		tEOF,
+	}},
+	{"order by", `.id order by .ts desc, .id asc limit 20 offset 40`, []lexeme{
+		{tokField, 0, ".id"},
+		{tokOrder, 0, "order"},
+		{tokBy, 0, "by"},
+		{tokField, 0, ".ts"},
+		{tokDesc, 0, "desc"},
+		tComma,
+		{tokField, 0, ".id"},
+		{tokAsc, 0, "asc"},
+		{tokLimit, 0, "limit"},
+		{tokNumber, 0, "20"},
+		{tokOffset, 0, "offset"},
+		{tokNumber, 0, "40"},
+		tEOF,
+	}},
+	{"distinct on", `distinct on (.id) .`, []lexeme{
+		{tokDistinct, 0, "distinct"},
+		{tokOn, 0, "on"},
+		tLparen,
+		{tokField, 0, ".id"},
+		tRparen,
+		{tokField, 0, "."},
+		tEOF,
+	}},
+	{"select as", `select .a.b as out.c`, []lexeme{
+		{tokSelect, 0, "select"},
+		{tokField, 0, ".a"},
+		{tokField, 0, ".b"},
+		{tokAs, 0, "as"},
+		{tokIdentifier, 0, "out"},
+		{tokField, 0, ".c"},
+		tEOF,
+	}},
+	{"malformed", `. where .name = "foobar"`, []lexeme{
+		{tokField, 0, "."},
+		tWhere,
+		{tokField, 0, ".name"},
+		{tokError, 0, "expected = after ="},
+	}},
 }
 
 func collect(t *lexTest) (items []lexeme) {
 	l := newLexer(t.input)
-	l.lex()
 	for {
 		item := l.nextLexeme()
 		items = append(items, item)
@@ -160,3 +324,24 @@ func TestLex(t *testing.T) {
 		equalLexemes(t, test.items, items)
 	}
 }
+
+func TestLexAfterEnd(t *testing.T) {
+	l := newLexer(".name")
+	equals(t, tokField, l.nextLexeme().tok)
+	equals(t, tokEOF, l.nextLexeme().tok)
+	equals(t, tokEOF, l.nextLexeme().tok)
+
+	l = newLexer(`"foobar`)
+	equals(t, tokError, l.nextLexeme().tok)
+	equals(t, tokError, l.nextLexeme().tok)
+}
+
+func TestLexPositions(t *testing.T) {
+	l := newLexer(`.id where .name == "foo"`)
+
+	var positions []int
+	for item := l.nextLexeme(); item.tok != tokEOF; item = l.nextLexeme() {
+		positions = append(positions, item.pos)
+	}
+	equals(t, []int{0, 4, 10, 16, 19}, positions)
+}
@@ -4,9 +4,9 @@ package haddoque
 
 import "fmt"
 
-const _nodeType_name = "nodeChainnodeListnodeBoolnodeTextnodeNumbernodeWherenodeAndnodeOrnodeInnodeContainsnodeOperation"
+const _nodeType_name = "nodeChainnodeSeqnodeBoolnodeTextnodeNumbernodeWherenodeAndnodeOrnodeInnodeContainsnodeOperationnodeQuantifiernodeNotnodeNullnodeIsNullnodeExistsnodeMatchnodeCallnodeArithnodeNegnodeObjectnodeAggregatenodeGroupnodeOrdernodeLimitnodeDistinctnodeComputednodeAlias"
 
-var _nodeType_index = [...]uint8{0, 9, 17, 25, 33, 43, 52, 59, 65, 71, 83, 96}
+var _nodeType_index = [...]uint16{0, 9, 16, 24, 32, 42, 51, 58, 64, 70, 82, 95, 109, 116, 124, 134, 144, 153, 161, 170, 177, 187, 200, 209, 218, 227, 239, 251, 260}
 
 func (i nodeType) String() string {
 	if i < 0 || i+1 >= nodeType(len(_nodeType_index)) {
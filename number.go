@@ -0,0 +1,170 @@
+package haddoque
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// normalizeNumber converts any Go numeric value or json.Number to one of int64, uint64, *big.Int or float64.
+//
+// Unsigned integers are kept as uint64 only if they don't fit in an int64. A json.Number
+// holding an integer is kept exact, using a *big.Int if needed; any other json.Number is converted
+// to float64, exactly like encoding/json does without UseNumber.
+func normalizeNumber(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case int64:
+		return t, true
+	case float64:
+		return t, true
+	case int:
+		return int64(t), true
+	case int8:
+		return int64(t), true
+	case int16:
+		return int64(t), true
+	case int32:
+		return int64(t), true
+	case uint:
+		return normalizeUint(uint64(t)), true
+	case uint8:
+		return int64(t), true
+	case uint16:
+		return int64(t), true
+	case uint32:
+		return int64(t), true
+	case uint64:
+		return normalizeUint(t), true
+	case uintptr:
+		return normalizeUint(uint64(t)), true
+	case float32:
+		return float64(t), true
+	case json.Number:
+		return normalizeJSONNumber(t)
+	case *big.Int:
+		if t.IsInt64() {
+			return t.Int64(), true
+		}
+		return t, true
+	default:
+		return nil, false
+	}
+}
+
+func normalizeUint(u uint64) interface{} {
+	if u <= math.MaxInt64 {
+		return int64(u)
+	}
+
+	return u
+}
+
+func normalizeJSONNumber(n json.Number) (interface{}, bool) {
+	s := string(n)
+
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+
+		if i, ok := new(big.Int).SetString(s, 10); ok {
+			return i, true
+		}
+
+		return nil, false
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	return f, true
+}
+
+// compareNumbers compares two numbers of any kind, returning -1, 0 or +1.
+//
+// The comparison is exact: integers are never converted to float64, so big identifiers
+// beyond 2^53 are correctly told apart. NaN can't be compared and returns false.
+func compareNumbers(l, r interface{}) (int, bool) {
+	ln, ok := normalizeNumber(l)
+	if !ok {
+		return 0, false
+	}
+
+	rn, ok := normalizeNumber(r)
+	if !ok {
+		return 0, false
+	}
+
+	// fast path for the most common case
+	if li, ok := ln.(int64); ok {
+		if ri, ok := rn.(int64); ok {
+			switch {
+			case li < ri:
+				return -1, true
+			case li > ri:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	lf, lIsFloat := ln.(float64)
+	rf, rIsFloat := rn.(float64)
+	if (lIsFloat && math.IsNaN(lf)) || (rIsFloat && math.IsNaN(rf)) {
+		return 0, false
+	}
+
+	if !lIsFloat && !rIsFloat {
+		return toBigInt(ln).Cmp(toBigInt(rn)), true
+	}
+
+	return toBigFloat(ln).Cmp(toBigFloat(rn)), true
+}
+
+// toBigInt converts a normalized integer to a *big.Int.
+func toBigInt(v interface{}) *big.Int {
+	switch t := v.(type) {
+	case int64:
+		return big.NewInt(t)
+	case uint64:
+		return new(big.Int).SetUint64(t)
+	case *big.Int:
+		return t
+	default:
+		return nil
+	}
+}
+
+// toBigFloat converts a normalized number which isn't NaN to a *big.Float without losing precision.
+func toBigFloat(v interface{}) *big.Float {
+	if f, ok := v.(float64); ok {
+		return new(big.Float).SetFloat64(f)
+	}
+
+	return new(big.Float).SetInt(toBigInt(v))
+}
+
+// arithNumber converts a number of any kind to an int64 or a float64, the types arithmetic works on.
+//
+// Integers which don't fit in an int64 are converted to float64.
+func arithNumber(v interface{}) (interface{}, bool) {
+	n, ok := normalizeNumber(v)
+	if !ok {
+		return nil, false
+	}
+
+	switch t := n.(type) {
+	case uint64:
+		return float64(t), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(t).Float64()
+		return f, true
+	default:
+		return n, true
+	}
+}
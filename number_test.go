@@ -0,0 +1,80 @@
+package haddoque
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	for _, test := range []struct {
+		in    interface{}
+		exp   interface{}
+		found bool
+	}{
+		{int(1), int64(1), true},
+		{int8(-2), int64(-2), true},
+		{int16(3), int64(3), true},
+		{int32(-4), int64(-4), true},
+		{int64(5), int64(5), true},
+		{uint(6), int64(6), true},
+		{uint8(7), int64(7), true},
+		{uint16(8), int64(8), true},
+		{uint32(9), int64(9), true},
+		{uint64(10), int64(10), true},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64), true},
+		{float32(1.5), 1.5, true},
+		{1.5, 1.5, true},
+		{json.Number("42"), int64(42), true},
+		{json.Number("-42"), int64(-42), true},
+		{json.Number("4.2e1"), 42.0, true},
+		{json.Number("123456789012345678901234567890"), big1, true},
+		{json.Number("foo"), nil, false},
+		{big.NewInt(12), int64(12), true},
+		{"42", nil, false},
+		{true, nil, false},
+		{nil, nil, false},
+	} {
+		res, found := normalizeNumber(test.in)
+		equals(t, test.found, found)
+		equals(t, test.exp, res)
+	}
+}
+
+func TestCompareNumbers(t *testing.T) {
+	for _, test := range []struct {
+		l, r interface{}
+		exp  int
+		ok   bool
+	}{
+		{int64(1), int64(2), -1, true},
+		{int64(2), int64(2), 0, true},
+		{int(3), uint8(2), 1, true},
+		{int64(1), 1.5, -1, true},
+		{1.5, int64(1), 1, true},
+		{int64(2), 2.0, 0, true},
+		{float32(0.5), 0.5, 0, true},
+		{uint64(math.MaxUint64), int64(math.MaxInt64), 1, true},
+		{uint64(math.MaxUint64), float64(math.MaxUint64), -1, true},
+		{int64(-1), uint64(math.MaxUint64), -1, true},
+		// beyond 2^53 two different integers have the same float64 representation
+		{json.Number("9007199254740993"), int64(9007199254740992), 1, true},
+		{json.Number("9007199254740993"), json.Number("9007199254740993"), 0, true},
+		{json.Number("9007199254740993"), float64(9007199254740992), 1, true},
+		{json.Number("123456789012345678901234567890"), json.Number("123456789012345678901234567891"), -1, true},
+		{json.Number("123456789012345678901234567890"), 1.2345678901234568e29, 1, true},
+		{json.Number("0.1"), 0.1, 0, true},
+		{math.NaN(), 1.0, 0, false},
+		{int64(1), math.NaN(), 0, false},
+		{math.Inf(1), int64(math.MaxInt64), 1, true},
+		{"1", int64(1), 0, false},
+		{int64(1), nil, 0, false},
+	} {
+		c, ok := compareNumbers(test.l, test.r)
+		equals(t, test.ok, ok)
+		equals(t, test.exp, c)
+	}
+}
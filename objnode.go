@@ -2,6 +2,9 @@ package haddoque
 
 import (
 	"errors"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -9,13 +12,21 @@ var (
 	errStopWalk = errors.New("haddoque: walk stopped")
 )
 
+// objKind is the kind of JSON value an objNode holds.
+type objKind int
+
+const (
+	objObject objKind = iota // fields are the members of the object
+	objArray                 // fields are the elements of the array
+	objValue                 // value is a scalar: string, number or bool
+	objNull                  // explicit null value
+)
+
 type objNode struct {
+	kind   objKind
 	name   string
 	value  interface{}
 	fields []*objNode
-
-	path        string
-	cachedPaths map[string]struct{}
 }
 
 type walkObjNodeFn func(path string, node *objNode) error
@@ -25,8 +36,14 @@ func walkObjNode(path string, root *objNode, fn walkObjNodeFn) error {
 		return err
 	}
 
-	for _, v := range root.fields {
-		p := makePath(path, v.name)
+	for i, v := range root.fields {
+		var p string
+		if root.kind == objArray {
+			p = makeIndexPath(path, i)
+		} else {
+			p = makePath(path, v.name)
+		}
+
 		if err := walkObjNode(p, v, fn); err != nil {
 			return err
 		}
@@ -39,26 +56,68 @@ func makePath(path, name string) string {
 	return strings.Join([]string{path, name}, ".")
 }
 
+func makeIndexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// newObjNode creates the tree of the object obj.
+//
+// obj can be a map[string]interface{} as decoded by encoding/json, or any Go value
+// which encodes to a JSON object or array: a struct, a map, a slice or a pointer to them.
+// It returns nil for any other value, or if the value contains a cycle.
 func newObjNode(obj interface{}) *objNode {
-	v, ok := obj.(map[string]interface{})
-	if !ok {
+	var c cycleDetector
+
+	on := newObjNode1(&objNode{}, ".", obj, &c)
+	if on == nil || (on.kind != objObject && on.kind != objArray) {
 		return nil
 	}
 
-	return newObjNode1(&objNode{}, ".", v)
+	return on
 }
 
-func newObjNode1(on *objNode, name string, obj interface{}) *objNode {
+func newObjNode1(on *objNode, name string, obj interface{}, c *cycleDetector) *objNode {
 	on.name = name
 
 	switch v := obj.(type) {
 	case map[string]interface{}:
+		if !c.enter(reflect.ValueOf(v)) {
+			return nil
+		}
 		for k, el := range v {
-			newOn := newObjNode1(&objNode{}, k, el)
+			newOn := newObjNode1(&objNode{}, k, el, c)
+			if newOn == nil {
+				return nil
+			}
 			on.fields = append(on.fields, newOn)
 		}
-	case interface{}:
+		c.leave(reflect.ValueOf(v))
+	case []interface{}:
+		if !c.enter(reflect.ValueOf(v)) {
+			return nil
+		}
+		on.kind = objArray
+		on.fields = make([]*objNode, len(v))
+		for i, el := range v {
+			if on.fields[i] = newObjNode1(&objNode{}, strconv.Itoa(i), el, c); on.fields[i] == nil {
+				return nil
+			}
+		}
+		c.leave(reflect.ValueOf(v))
+	case nil:
+		on.kind = objNull
+	case string, bool:
+		on.kind = objValue
 		on.value = obj
+	default:
+		if _, ok := normalizeNumber(obj); ok {
+			on.kind = objValue
+			on.value = obj
+			break
+		}
+
+		// any other Go value is handled like its JSON encoding
+		return newReflectObjNode(on, reflect.ValueOf(obj), c)
 	}
 
 	return on
@@ -67,36 +126,42 @@ func newObjNode1(on *objNode, name string, obj interface{}) *objNode {
 func (n *objNode) makeAllPaths() []string {
 	var res []string
 	walkObjNode("", n, func(path string, node *objNode) error {
-		if path == "" {
-			node.path = "."
-		} else {
-			node.path = path
-		}
 		res = append(res, path)
 		return nil
 	})
 	return res
 }
 
-func (n *objNode) populateCachedPaths() {
-	if len(n.cachedPaths) == 0 {
-		n.cachedPaths = make(map[string]struct{})
-		for _, p := range n.makeAllPaths() {
-			n.cachedPaths[p] = struct{}{}
-		}
-		n.cachedPaths["."] = struct{}{}
+// Paths returns the paths of all the values in the data, written like field selectors:
+// ".user.name" or ".tags[0]". They're sorted, so a value comes before its fields.
+//
+// The data can be anything accepted by Query.Exec, Paths returns nil for anything else.
+func Paths(obj interface{}) []string {
+	on := newObjNode(obj)
+	if on == nil {
+		return nil
 	}
-}
 
-func (n *objNode) hasPath(path string) bool {
-	n.populateCachedPaths()
-	_, ok := n.cachedPaths[path]
-	return ok
+	// the first path is the root itself
+	paths := on.makeAllPaths()[1:]
+	sort.Strings(paths)
+
+	return paths
 }
 
 func (n *objNode) data() interface{} {
-	if n.value != nil {
+	switch n.kind {
+	case objValue:
 		return n.value
+	case objNull:
+		return nil
+	case objArray:
+		res := make([]interface{}, len(n.fields))
+		for i, f := range n.fields {
+			res[i] = f.data()
+		}
+
+		return res
 	}
 
 	res := make(map[string]interface{})
@@ -107,59 +172,168 @@ func (n *objNode) data() interface{} {
 	return res
 }
 
-func (n *objNode) findSubNode(path string) *objNode {
-	if n.path == path {
-		return n
-	}
+// objMatch is a node selected by a chain along with its location in the object.
+//
+// The location is made of map keys (string) and array indexes (int).
+type objMatch struct {
+	node *objNode
+	loc  []interface{}
+}
 
-	for _, f := range n.fields {
-		if sn := f.findSubNode(path); sn != nil {
-			return sn
+// resolve follows the steps of a chain and returns all the nodes they select.
+func (n *objNode) resolve(steps []step) []objMatch {
+	matches := []objMatch{{node: n}}
+	for _, s := range steps {
+		var next []objMatch
+		for _, m := range matches {
+			next = m.node.follow(s, m.loc, next)
 		}
+		matches = next
 	}
 
-	return nil
+	return matches
 }
 
-func (n *objNode) get(path string) interface{} {
-	n.populateCachedPaths()
-	if _, ok := n.cachedPaths[path]; !ok {
-		return nil
-	}
+// follow applies a single step to the node and appends the selected nodes to res.
+func (n *objNode) follow(s step, loc []interface{}, res []objMatch) []objMatch {
+	// the capacity is capped so that append always copies the parent location
+	loc = loc[:len(loc):len(loc)]
 
-	sn := n.findSubNode(path)
-	if sn == nil {
-		return nil
+	switch s.kind {
+	case stepField:
+		if n.kind != objObject {
+			return res
+		}
+
+		for _, f := range n.fields {
+			if f.name == s.name {
+				return append(res, objMatch{node: f, loc: append(loc, f.name)})
+			}
+		}
+	case stepIndex:
+		if n.kind != objArray {
+			return res
+		}
+
+		i := s.index
+		if i < 0 {
+			i += len(n.fields)
+		}
+		if i >= 0 && i < len(n.fields) {
+			return append(res, objMatch{node: n.fields[i], loc: append(loc, i)})
+		}
+	case stepSlice:
+		if n.kind != objArray {
+			return res
+		}
+
+		lo, hi := 0, len(n.fields)
+		if s.hasLo {
+			lo = clampIndex(s.index, len(n.fields))
+		}
+		if s.hasHi {
+			hi = clampIndex(s.hi, len(n.fields))
+		}
+
+		for i := lo; i < hi; i++ {
+			res = append(res, objMatch{node: n.fields[i], loc: append(loc, i)})
+		}
+	case stepWildcard:
+		if n.kind != objObject && n.kind != objArray {
+			return res
+		}
+
+		for i, f := range n.fields {
+			if n.kind == objArray {
+				res = append(res, objMatch{node: f, loc: append(loc, i)})
+			} else {
+				res = append(res, objMatch{node: f, loc: append(loc, f.name)})
+			}
+		}
 	}
 
-	return sn.data()
+	return res
 }
 
-func (n *objNode) chainParts() []string {
-	return strings.Split(n.path, ".")
+// clampIndex converts a possibly negative slice bound to an index in [0, length].
+func clampIndex(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+
+	switch {
+	case i < 0:
+		return 0
+	case i > length:
+		return length
+	default:
+		return i
+	}
 }
 
-func mergeNodes(nodes []*objNode) map[string]interface{} {
-	if len(nodes) == 1 {
-		return nodes[0].data().(map[string]interface{})
+// projArray is a sparse array used while building a projection, indexed by the position in the source array.
+type projArray map[int]interface{}
+
+// project sets data at the location loc in dst, creating the intermediate maps and arrays as needed,
+// and returns the resulting value.
+//
+// Arrays are built as projArray, finishProjection must be called on the final result.
+func project(dst interface{}, loc []interface{}, data interface{}) interface{} {
+	if len(loc) == 0 {
+		return data
 	}
 
-	res := make(map[string]interface{})
-	for _, n := range nodes {
-		makeChainParts(res, n.chainParts(), n.data())
+	switch k := loc[0].(type) {
+	case string:
+		m, ok := dst.(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{})
+		}
+		m[k] = project(m[k], loc[1:], data)
+
+		return m
+	case int:
+		a, ok := dst.(projArray)
+		if !ok {
+			a = make(projArray)
+
+			// an array already selected as a whole stays whole
+			if elems, isArray := dst.([]interface{}); isArray {
+				for i, el := range elems {
+					a[i] = el
+				}
+			}
+		}
+		a[k] = project(a[k], loc[1:], data)
+
+		return a
 	}
 
-	return res
+	return dst
 }
 
-func makeChainParts(m map[string]interface{}, parts []string, data interface{}) {
-	current := m
-	for i, p := range parts[1:] {
-		if i+1 >= len(parts)-1 {
-			current[p] = data
-			break
+// finishProjection converts all the projArray in a projection to regular slices,
+// keeping the elements in the order of the source arrays.
+func finishProjection(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, el := range t {
+			t[k] = finishProjection(el)
+		}
+	case projArray:
+		indexes := make([]int, 0, len(t))
+		for i := range t {
+			indexes = append(indexes, i)
 		}
-		current[p] = make(map[string]interface{})
-		current = current[p].(map[string]interface{})
+		sort.Ints(indexes)
+
+		res := make([]interface{}, len(indexes))
+		for i, idx := range indexes {
+			res[i] = finishProjection(t[idx])
+		}
+
+		return res
 	}
+
+	return v
 }
@@ -9,16 +9,16 @@ var (
 	r = &objNode{
 		fields: []*objNode{
 			{name: "data", fields: []*objNode{
-				{name: "id", value: 1},
-				{name: "name", value: "Vincent"},
+				{kind: objValue, name: "id", value: 1},
+				{kind: objValue, name: "name", value: "Vincent"},
 				{name: "platform", fields: []*objNode{
-					{name: "type", value: "mobile"},
-					{name: "value", value: "android"},
+					{kind: objValue, name: "type", value: "mobile"},
+					{kind: objValue, name: "value", value: "android"},
 				}},
 			}},
 			{name: "locale", fields: []*objNode{
-				{name: "language", value: "fr"},
-				{name: "region", value: "FR"},
+				{kind: objValue, name: "language", value: "fr"},
+				{kind: objValue, name: "region", value: "FR"},
 			}},
 		},
 	}
@@ -36,15 +36,40 @@ func TestObjNode(t *testing.T) {
 	equals(t, exp, paths)
 }
 
-func TestObjNodeHasPath(t *testing.T) {
-	equals(t, true, r.hasPath(".data"))
-	equals(t, true, r.hasPath(".data.platform.type"))
-	equals(t, false, r.hasPath(".foobar"))
+func TestPaths(t *testing.T) {
+	m := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": "Vincent",
+			"tags": []interface{}{"a", "b"},
+		},
+		"id": 1,
+	}
+
+	exp := []string{".id", ".user", ".user.name", ".user.tags", ".user.tags[0]", ".user.tags[1]"}
+	equals(t, exp, Paths(m))
+
+	equals(t, []string(nil), Paths("foobar"))
+}
+
+// resolvePath returns the data of the nodes selected by the field selector.
+func resolvePath(t *testing.T, on *objNode, selector string) []interface{} {
+	q, err := Compile(selector)
+	ok(t, err)
+
+	var res []interface{}
+	for _, m := range on.resolve(q.root.nodes[0].(*chainNode).steps) {
+		res = append(res, m.node.data())
+	}
+
+	return res
 }
 
-func TestObjNodeGet(t *testing.T) {
-	equals(t, 1, r.get(".data.id"))
-	equals(t, "FR", r.get(".locale.region"))
+func TestObjNodeResolvePath(t *testing.T) {
+	equals(t, 1, len(resolvePath(t, r, ".data")))
+	equals(t, []interface{}{"mobile"}, resolvePath(t, r, ".data.platform.type"))
+	equals(t, []interface{}(nil), resolvePath(t, r, ".foobar"))
+	equals(t, []interface{}{1}, resolvePath(t, r, ".data.id"))
+	equals(t, []interface{}{"FR"}, resolvePath(t, r, ".locale.region"))
 }
 
 func TestNewObjNode(t *testing.T) {
@@ -64,24 +89,103 @@ func TestNewObjNode(t *testing.T) {
 		"shards": []int{1, 2, 3},
 	}
 
+	exp := []string{
+		".data", ".data.id", ".data.name", ".data.platform",
+		".data.platform.type", ".data.platform.value",
+		".locale", ".locale.language", ".locale.region",
+		".shards", ".shards[0]", ".shards[1]", ".shards[2]",
+	}
+	equals(t, exp, Paths(m))
+
 	on := newObjNode(m)
-	paths := on.makeAllPaths()
+	for selector, exp := range map[string]interface{}{
+		".data.id":             1,
+		".data.name":           "Vincent",
+		".data.platform.type":  "mobile",
+		".data.platform.value": "android",
+		".locale.language":     "fr",
+		".locale.region":       "FR",
+		".shards":              []interface{}{int64(1), int64(2), int64(3)},
+	} {
+		equals(t, []interface{}{exp}, resolvePath(t, on, selector))
+	}
+}
 
-	sort.Strings(paths)
+func TestObjNodeArrays(t *testing.T) {
+	m := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"sku": "a", "qty": int64(1)},
+			map[string]interface{}{"sku": "b", "qty": int64(2)},
+			map[string]interface{}{"sku": "c", "qty": int64(3)},
+		},
+	}
 
 	exp := []string{
-		"", ".data", ".data.id", ".data.name", ".data.platform",
-		".data.platform.type", ".data.platform.value",
-		".locale", ".locale.language", ".locale.region",
-		".shards",
+		".items",
+		".items[0]", ".items[0].qty", ".items[0].sku",
+		".items[1]", ".items[1].qty", ".items[1].sku",
+		".items[2]", ".items[2].qty", ".items[2].sku",
 	}
-	equals(t, exp, paths)
+	equals(t, exp, Paths(m))
+
+	on := newObjNode(m)
+	equals(t, []interface{}{"b"}, resolvePath(t, on, ".items[1].sku"))
+	equals(t, []interface{}{m["items"]}, resolvePath(t, on, ".items"))
+}
+
+type resolveTest struct {
+	steps []step
+	locs  [][]interface{}
+}
+
+var resolveTests = []resolveTest{
+	{nil, [][]interface{}{nil}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepIndex, index: 1}, {kind: stepField, name: "sku"}},
+		[][]interface{}{{"items", 1, "sku"}}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepIndex, index: -1}},
+		[][]interface{}{{"items", 2}}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepIndex, index: 3}},
+		nil},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepSlice, index: 1, hasLo: true}},
+		[][]interface{}{{"items", 1}, {"items", 2}}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepSlice, hi: -1, hasHi: true}},
+		[][]interface{}{{"items", 0}, {"items", 1}}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepSlice, index: -10, hasLo: true, hi: 10, hasHi: true}},
+		[][]interface{}{{"items", 0}, {"items", 1}, {"items", 2}}},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepWildcard}, {kind: stepField, name: "sku"}},
+		[][]interface{}{{"items", 0, "sku"}, {"items", 1, "sku"}, {"items", 2, "sku"}}},
+	{[]step{{kind: stepField, name: "name"}, {kind: stepIndex, index: 0}},
+		nil},
+	{[]step{{kind: stepField, name: "items"}, {kind: stepField, name: "sku"}},
+		nil},
+}
+
+func TestObjNodeResolve(t *testing.T) {
+	on := newObjNode(map[string]interface{}{
+		"name": "foobar",
+		"items": []interface{}{
+			map[string]interface{}{"sku": "a"},
+			map[string]interface{}{"sku": "b"},
+			map[string]interface{}{"sku": "c"},
+		},
+	})
+
+	for _, test := range resolveTests {
+		var locs [][]interface{}
+		for _, m := range on.resolve(test.steps) {
+			locs = append(locs, m.loc)
+		}
+		equals(t, test.locs, locs)
+	}
+}
+
+func TestObjNodeNull(t *testing.T) {
+	on := newObjNode(map[string]interface{}{
+		"a": nil,
+		"b": map[string]interface{}{},
+	})
 
-	equals(t, 1, on.get(".data.id"))
-	equals(t, "Vincent", on.get(".data.name"))
-	equals(t, "mobile", on.get(".data.platform.type"))
-	equals(t, "android", on.get(".data.platform.value"))
-	equals(t, "fr", on.get(".locale.language"))
-	equals(t, "FR", on.get(".locale.region"))
-	equals(t, []int{1, 2, 3}, on.get(".shards"))
+	equals(t, []interface{}{nil}, resolvePath(t, on, ".a"))
+	equals(t, []interface{}{map[string]interface{}{}}, resolvePath(t, on, ".b"))
+	equals(t, map[string]interface{}{"a": nil, "b": map[string]interface{}{}}, on.data())
 }
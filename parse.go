@@ -1,7 +1,12 @@
 package haddoque
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -13,6 +18,9 @@ import (
 type tree struct {
 	root  *seqNode
 	lexer *lexer
+	funcs map[string]reflect.Value
+	// first lexemes of the ORDER BY keys, to locate errors
+	orderLexemes []lexeme
 	// buffer for peeking
 	peekBuffer [2]lexeme
 	peekCount  int
@@ -51,10 +59,44 @@ func (t *tree) backup() {
 	t.peekCount++
 }
 
-// errorf panics with a formatted error.
-func (t *tree) errorf(format string, args ...interface{}) {
+// backup2 goes back two lexemes, l being the first one and the last one read being still in the buffer.
+func (t *tree) backup2(l lexeme) {
+	t.peekBuffer[1] = l
+	t.peekCount = 2
+}
+
+// errorf panics with a SyntaxError located at the lexeme l.
+func (t *tree) errorf(l lexeme, format string, args ...interface{}) {
+	t.errorExpected(l, nil, format, args...)
+}
+
+// errorExpected panics with a SyntaxError located at the lexeme l, listing what was expected instead.
+func (t *tree) errorExpected(l lexeme, expected []string, format string, args ...interface{}) {
 	t.root = nil
-	panic(fmt.Errorf(format, args...))
+
+	var text string
+	switch l.tok {
+	case tokEOF:
+	case tokError:
+		// the lexer stops at the first error, so its position marks the end of the bad input
+		text = t.lexer.input[l.pos:t.lexer.pos]
+	default:
+		text = l.val
+	}
+
+	panic(newSyntaxError(t.lexer.input, l.pos, text, expected, fmt.Sprintf(format, args...)))
+}
+
+// unexpected panics with a SyntaxError describing the unexpected lexeme l.
+func (t *tree) unexpected(l lexeme, expected ...string) {
+	switch l.tok {
+	case tokEOF:
+		t.errorExpected(l, expected, "unexpected end of query")
+	case tokError:
+		t.errorExpected(l, expected, "%s", l.val)
+	default:
+		t.errorExpected(l, expected, "unexpected %q", l.val)
+	}
 }
 
 // recover catches panics and set the attached error to errp if it's not a runtime.Error
@@ -76,155 +118,641 @@ func (t *tree) parse() (err error) {
 	defer t.recover(&err)
 	t.root = newSeqNode()
 
+	// an optional SELECT keyword and DISTINCT clause come first, the clause is stored after the selected fields
+	if t.peek().tok == tokSelect {
+		t.nextLexeme()
+	}
+
+	var distinct node
+	start := t.peek()
+	if start.tok == tokDistinct {
+		distinct = t.parseDistinct()
+	}
+
+	// then field selectors, computed fields and aggregates, optionally renamed
+	var fields []lexeme
+	var aliases []*aliasNode
+	var aliasLexemes []lexeme
 	p := t.peek()
+	for ; p.tok == tokField || p.tok == tokIdentifier || p.tok == tokComma; p = t.peek() {
+		if p.tok == tokComma {
+			t.nextLexeme()
+			continue
+		}
+
+		var n node
+		if p.tok == tokIdentifier && t.isAggregate(p.val) {
+			n = t.parseAggregate()
+		} else {
+			n = t.parseProjection()
+		}
+
+		if l := t.peek(); l.tok == tokAs {
+			alias := t.parseAlias(n)
+			aliases = append(aliases, alias)
+			aliasLexemes = append(aliasLexemes, l)
+			n = alias
+		}
+
+		t.root.nodes = append(t.root.nodes, n)
+		fields = append(fields, p)
+	}
+
+	for i, a := range aliases {
+		for _, b := range aliases[:i] {
+			if hasPathPrefix(a.path, b.path) || hasPathPrefix(b.path, a.path) {
+				t.errorf(aliasLexemes[i], "alias %s conflicts with %s", strings.Join(a.path, "."), strings.Join(b.path, "."))
+			}
+		}
+	}
+
+	if distinct != nil {
+		t.root.nodes = append(t.root.nodes, distinct)
+	}
+
+	// then the clauses, each one at most once
+	seen := make(map[token]bool)
 	for ; p.tok != tokEOF; p = t.peek() {
+		if seen[p.tok] {
+			t.errorf(p, "duplicate %s clause", p.val)
+		}
+		seen[p.tok] = true
+
 		switch p.tok {
-		case tokComma:
-			t.nextLexeme()
-		case tokField:
-			n := t.parseChain(new([]string))
-			t.root.nodes = append(t.root.nodes, n)
 		case tokWhere:
 			n := t.parseWhere()
 			t.root.nodes = append(t.root.nodes, n)
+		case tokGroup:
+			n := t.parseGroupBy()
+			t.root.nodes = append(t.root.nodes, n)
+		case tokOrder:
+			n := t.parseOrderBy()
+			t.root.nodes = append(t.root.nodes, n)
+		case tokLimit, tokOffset:
+			n := t.parseLimit()
+			t.root.nodes = append(t.root.nodes, n)
 		default:
-			t.nextLexeme()
+			t.unexpected(p, "field", `","`, `"where"`, `"group by"`, `"order by"`, `"limit"`, `"offset"`)
 		}
 	}
 
+	t.checkAggregates(fields)
+
+	if distinct != nil && (findGroup(t.root) != nil || hasAggregates(t.root)) {
+		t.errorf(start, "distinct can't be used in an aggregate query")
+	}
+
 	return nil
 }
 
-// parseChain parses a chain of fields
-//
-// NOTE(vincent): with how it's written right now, we need to pass a non empty slice at the first call.
-// It'll need to be changed in the future.
-func (t *tree) parseChain(fields *[]string) node {
+// parseProjection parses a field selector, a function call or a computed field
+func (t *tree) parseProjection() node {
+	start := t.peek()
+
+	n := t.parseExpr(precAdditive)
+	if n.typ() == nodeChain || n.typ() == nodeCall {
+		return n
+	}
+
+	return &computedNode{
+		nodeType: nodeComputed,
+		expr:     n,
+		text:     strings.TrimSpace(t.lexer.input[start.pos:t.peek().pos]),
+	}
+}
+
+// parseAlias parses an AS construct renaming the selected field n, like "as first" or "as out.meta.id"
+func (t *tree) parseAlias(n node) *aliasNode {
+	t.nextLexeme()
+
+	return &aliasNode{
+		nodeType: nodeAlias,
+		expr:     n,
+		path:     t.parseAliasPath(),
+	}
+}
+
+// parseAliasRef parses a reference to the alias of a selected field, and returns the expression of the field.
+func (t *tree) parseAliasRef() node {
+	l := t.peek()
+	path := t.parseAliasPath()
+
+	for _, v := range projections(t.root) {
+		if a, ok := v.(*aliasNode); ok && len(a.path) == len(path) && hasPathPrefix(a.path, path) {
+			return a.expr
+		}
+	}
+
+	t.errorf(l, "unknown alias %s", strings.Join(path, "."))
+
+	return nil
+}
+
+// parseAliasPath parses the name of an alias, an identifier or a string optionally followed by fields.
+func (t *tree) parseAliasPath() []string {
+	var path []string
+	switch l := t.nextLexeme(); l.tok {
+	case tokIdentifier:
+		path = append(path, l.val)
+	case tokString:
+		name, err := strconv.Unquote(l.val)
+		if err != nil {
+			t.errorf(l, "bad string syntax %s", l.val)
+		}
+		path = append(path, name)
+	default:
+		t.unexpected(l, "name")
+	}
+
+	for t.peek().tok == tokField {
+		l := t.nextLexeme()
+		if l.val == "." {
+			t.unexpected(l, "name")
+		}
+		path = append(path, l.val[1:])
+	}
+
+	return path
+}
+
+// hasPathPrefix reports whether prefix is a prefix of path, or path itself.
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkAggregates checks that a query with aggregates or a GROUP BY clause only selects and orders by aggregates
+// and group keys, fields being the first lexemes of the selected fields. Aggregates can't be used in any other query.
+func (t *tree) checkAggregates(fields []lexeme) {
+	var order *orderNode
+	for _, v := range t.root.nodes {
+		if o, ok := v.(*orderNode); ok {
+			order = o
+		}
+	}
+
+	group := findGroup(t.root)
+	if group == nil && !hasAggregates(t.root) {
+		if order != nil {
+			for i, k := range order.keys {
+				if k.typ() == nodeAggregate {
+					t.errorf(t.orderLexemes[i], "aggregate %s can only be used in an aggregate query", k.(*aggregateNode).name)
+				}
+			}
+		}
+		return
+	}
+
+	keys := make(map[string]bool)
+	if group != nil {
+		for _, k := range group.keys {
+			keys[sourceText(k)] = true
+		}
+	}
+
+	for i, n := range projections(t.root) {
+		if unalias(n).typ() != nodeAggregate && !keys[sourceText(n)] {
+			t.errorf(fields[i], "%s must be an aggregate or a group by key", sourceText(n))
+		}
+	}
+
+	if order != nil {
+		for i, k := range order.keys {
+			if k.typ() != nodeAggregate && !keys[sourceText(k)] {
+				t.errorf(t.orderLexemes[i], "order by key must be an aggregate or a group by key")
+			}
+		}
+	}
+}
+
+// sourceText returns the source text of a field selector, a function call, an aggregate or a computed field.
+// The text of a renamed field is the text of its expression.
+func sourceText(n node) string {
+	switch v := n.(type) {
+	case *chainNode:
+		return v.chain
+	case *callNode:
+		return v.text
+	case *aggregateNode:
+		return v.text
+	case *computedNode:
+		return v.text
+	case *aliasNode:
+		return sourceText(v.expr)
+	default:
+		return ""
+	}
+}
+
+// parseChain parses a chain of fields and array selectors, optionally ending with a ?
+func (t *tree) parseChain() node {
 	n := &chainNode{nodeType: nodeChain}
-	defer func() {
-		n.chain = strings.Join(*fields, "")
-		fields = nil
-	}()
 
-	switch l := t.nextLexeme(); {
-	case l.tok == tokField:
-		*fields = append(*fields, l.val)
-		return t.parseChain(fields)
+	var buf bytes.Buffer
+	for {
+		switch l := t.nextLexeme(); {
+		case l.tok == tokField:
+			buf.WriteString(l.val)
+			if l.val != "." {
+				n.steps = append(n.steps, step{kind: stepField, name: l.val[1:]})
+			}
+		case l.tok == tokLbracket && buf.Len() > 0:
+			buf.WriteString(l.val)
+			n.steps = append(n.steps, t.parseSelector(&buf))
+		case l.tok == tokQuestion && buf.Len() > 0:
+			// an optional marker ends the chain
+			buf.WriteString(l.val)
+			n.chain = buf.String()
+			n.optional = true
+			return n
+		default:
+			t.backup()
+			n.chain = buf.String()
+			return n
+		}
 	}
-	t.backup()
+}
 
-	return n
+// parseSelector parses an array selector, the opening bracket being already consumed.
+//
+// The text of the selector is written to buf.
+func (t *tree) parseSelector(buf *bytes.Buffer) step {
+	var s step
+
+	l := t.nextLexeme()
+	buf.WriteString(l.val)
+
+	switch l.tok {
+	case tokStar:
+		s.kind = stepWildcard
+		l = t.nextLexeme()
+		buf.WriteString(l.val)
+	case tokNumber, tokMinus, tokColon:
+		s.kind = stepIndex
+		if l.tok != tokColon {
+			s.index, s.hasLo = t.parseIndex(l, buf), true
+			l = t.nextLexeme()
+			buf.WriteString(l.val)
+		}
+
+		if l.tok == tokColon {
+			s.kind = stepSlice
+			if l = t.nextLexeme(); l.tok == tokNumber || l.tok == tokMinus {
+				buf.WriteString(l.val)
+				s.hi, s.hasHi = t.parseIndex(l, buf), true
+				l = t.nextLexeme()
+			}
+			buf.WriteString(l.val)
+		}
+	default:
+		t.unexpected(l, "index", `":"`, `"*"`)
+	}
+
+	if l.tok != tokRbracket {
+		t.unexpected(l, `"]"`)
+	}
+
+	return s
+}
+
+// parseIndex parses an array index starting with the lexeme l, which can be a minus sign.
+//
+// The text of the index following l is written to buf.
+func (t *tree) parseIndex(l lexeme, buf *bytes.Buffer) int {
+	text := l.val
+	if l.tok == tokMinus {
+		n := t.expect(tokNumber, "index")
+		buf.WriteString(n.val)
+		text += n.val
+	}
+
+	i, err := strconv.Atoi(text)
+	if err != nil {
+		t.errorf(l, "bad array index %q", text)
+	}
+
+	return i
 }
 
 // parseWhere parses a WHERE construct
 func (t *tree) parseWhere() node {
-	t.nextLexeme()
+	w := t.nextLexeme()
+
+	if t.peek().tok == tokEOF {
+		t.errorf(w, "missing condition after where")
+	}
 
 	n := &whereNode{nodeType: nodeWhere}
 	n.condition = t.parseCondition()
 
-	ty := n.condition.typ()
-	if ty != nodeOr && ty != nodeAnd &&
-		ty != nodeIn && ty != nodeContains &&
-		ty != nodeOperation {
-		t.errorf("unexpected condition")
+	if !isConditionNode(n.condition) {
+		t.errorf(w, "invalid condition after where")
 	}
 
 	return n
 }
 
-// parseCondition parses a condition
-func (t *tree) parseCondition() node {
-	var n node
+// parseGroupBy parses a GROUP BY construct, the keys being fields or function calls
+func (t *tree) parseGroupBy() node {
+	t.nextLexeme()
+	t.expect(tokBy, `"by"`)
 
-	// Right now conditions are the last piece of a query, so parseCondition reads everything
-	// until EOF.
-	//
-	// Because it's easier to handle, we require that every condition is enclosed in a pair of ().
-	//
-	// It's probably not a good implementation, but bear with me, I only just now started working with complex parsers.
-	//
-	// Here an overview of how it works:
-	//  - a condition is just a node that can later be interpreted as "truthy".
-	//    Obviously any expression which evaluates to a bool are valid,
-	//    but single values like a number of string are valid too.
-	//  - since we don't know in advance what kind of expression we have on our hand
-	//    (remember, we use the grammer <lhs> <op> <rhs>), first time round
-	//    we consider the expression a unary expression.
-	//
-	// Next time round, if we encounter an operator, we simply transform the unary expression
-	// into a binary expression, and continue reading for the right hand side.
-	for l := t.peek(); l.tok != tokEOF; l = t.peek() {
-		switch {
+	n := &groupNode{nodeType: nodeGroup}
+	for {
+		switch l := t.peek(); {
 		case l.tok == tokField:
-			// TODO(vincent): error handling !
-			if n == nil {
-				n = t.parseChain(new([]string))
-			} else if isBinaryExprNode(n) {
-				t.setRightNode(n, t.parseChain(new([]string)))
-			}
-		case l.tok > tokLiteralsBegin && l.tok < tokLiteralsEnd:
-			if n == nil {
-				n = t.parseLiteral()
-			} else if isBinaryExprNode(n) {
-				t.setRightNode(n, t.parseLiteral())
-			}
-		case l.tok > tokOperatorsBegin && l.tok < tokOperatorsEnd:
-			t.nextLexeme()
-			n = &operationNode{
-				nodeType: nodeOperation,
-				left:     n,
-				operator: l.tok,
-			}
-		case l.tok > tokKeywordsBegin && l.tok < tokKeywordsEnd:
-			n = t.parseKeyword(n)
-		case l.tok == tokLbracket:
-			// TODO(vincent): error handling !
-			if isBinaryExprNode(n) {
-				t.setRightNode(n, t.parseLiteralSeq())
-			}
-		case l.tok == tokLparen:
-			t.nextLexeme() // consume
-			if n == nil {
-				n = t.parseCondition()
-			} else if isBinaryExprNode(n) {
-				t.setRightNode(n, t.parseCondition())
-			}
-		case l.tok == tokRparen:
-			t.nextLexeme() // consume
+			n.keys = append(n.keys, t.parseChain())
+		case l.tok == tokIdentifier && !t.isAggregate(l.val):
+			n.keys = append(n.keys, t.parseCall())
+		default:
+			t.unexpected(l, "field", "function call")
+		}
+
+		if t.peek().tok != tokComma {
 			return n
+		}
+		t.nextLexeme()
+	}
+}
+
+// parseOrderBy parses an ORDER BY construct, each key being optionally followed by asc or desc
+func (t *tree) parseOrderBy() node {
+	t.nextLexeme()
+	t.expect(tokBy, `"by"`)
+
+	n := &orderNode{nodeType: nodeOrder}
+	for {
+		l := t.peek()
+
+		var key node
+		switch {
+		case l.tok == tokIdentifier && t.isAggregate(l.val):
+			key = t.parseAggregate()
+		case l.tok == tokIdentifier && t.isAliasRef():
+			key = t.parseAliasRef()
 		default:
-			t.errorf("unexpected token %v", l.tok)
+			key = t.parseExpr(precAdditive)
+		}
+
+		if isConditionNode(key) && key.typ() != nodeCall {
+			t.errorf(l, "order by key must be a value")
+		}
+
+		desc := false
+		switch t.peek().tok {
+		case tokAsc:
+			t.nextLexeme()
+		case tokDesc:
+			t.nextLexeme()
+			desc = true
+		}
+
+		n.keys = append(n.keys, key)
+		n.desc = append(n.desc, desc)
+		t.orderLexemes = append(t.orderLexemes, l)
+
+		if t.peek().tok != tokComma {
+			return n
 		}
+		t.nextLexeme()
 	}
+}
+
+// parseDistinct parses a DISTINCT or DISTINCT ON (keys...) construct
+func (t *tree) parseDistinct() node {
+	t.nextLexeme()
+
+	n := &distinctNode{nodeType: nodeDistinct}
+	if t.peek().tok != tokOn {
+		return n
+	}
+	t.nextLexeme()
+	t.expect(tokLparen, `"("`)
+
+	for {
+		l := t.peek()
+
+		key := t.parseExpr(precAdditive)
+		if isConditionNode(key) && key.typ() != nodeCall {
+			t.errorf(l, "distinct on key must be a value")
+		}
+		n.keys = append(n.keys, key)
+
+		if t.peek().tok != tokComma {
+			break
+		}
+		t.nextLexeme()
+	}
+	t.expect(tokRparen, `","`, `")"`)
 
 	return n
 }
 
-func isBinaryExprNode(n node) bool {
-	switch n.(type) {
-	case *andNode, *orNode, *inNode, *containsNode, *operationNode:
+// isAliasRef reports whether the next lexeme, an identifier, is a reference to an alias and not a function call.
+func (t *tree) isAliasRef() bool {
+	l := t.nextLexeme()
+	next := t.peek()
+	t.backup2(l)
+
+	return next.tok != tokLparen
+}
+
+// parseLimit parses a LIMIT or OFFSET construct
+func (t *tree) parseLimit() node {
+	clause := t.nextLexeme()
+
+	l := t.expect(tokNumber, "number")
+	n, err := strconv.Atoi(l.val)
+	if err != nil {
+		t.errorf(l, "bad %s %q", clause.val, l.val)
+	}
+
+	return &limitNode{
+		nodeType: nodeLimit,
+		clause:   clause.tok,
+		n:        n,
+	}
+}
+
+// isConditionNode reports whether n can be evaluated as a condition
+func isConditionNode(n node) bool {
+	switch n.typ() {
+	case nodeOr, nodeAnd, nodeIn, nodeContains, nodeOperation,
+		nodeQuantifier, nodeNot, nodeIsNull, nodeExists, nodeMatch,
+		nodeCall:
 		return true
 	default:
 		return false
 	}
 }
 
-func (t *tree) setRightNode(n node, r node) {
-	switch v := n.(type) {
-	case *andNode:
-		v.right = r
-	case *orNode:
-		v.right = r
-	case *inNode:
-		v.right = r
-	case *containsNode:
-		v.right = r
-	case *operationNode:
-		v.right = r
+// precedence levels of the binary operators, from the loosest to the tightest.
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precCompare
+	precAdditive
+	precMultiplicative
+)
+
+// infixPrecedence returns the precedence of tok if it's a binary operator, precLowest otherwise.
+func infixPrecedence(tok token) int {
+	switch {
+	case tok == tokOr:
+		return precOr
+	case tok == tokAnd:
+		return precAnd
+	case tok == tokIn, tok == tokContains, tok == tokIs,
+		tok == tokMatches, tok == tokLike:
+		return precCompare
+	case tok > tokOperatorsBegin && tok < tokOperatorsEnd && tok != tokNot:
+		return precCompare
+	case tok == tokPlus, tok == tokMinus:
+		return precAdditive
+	case tok == tokStar, tok == tokSlash, tok == tokPercent:
+		return precMultiplicative
+	default:
+		return precLowest
+	}
+}
+
+// parseCondition parses a condition
+func (t *tree) parseCondition() node {
+	return t.parseExpr(precOr)
+}
+
+// parseExpr parses an expression by precedence climbing.
+//
+// Only the binary operators binding at least as tightly as minPrec are consumed, the others
+// are left for the caller. This is what makes arithmetic bind tighter than comparisons,
+// which bind tighter than "and", which binds tighter than "or":
+//
+//	.a == 1 and .b == 2 or .c == 3
+//
+// is parsed as
+//
+//	((.a == 1) and (.b == 2)) or (.c == 3)
+func (t *tree) parseExpr(minPrec int) node {
+	leftStart := t.peek()
+	left := t.parseOperand()
+
+	for {
+		l := t.peek()
+		if l.tok == tokError {
+			t.unexpected(l)
+		}
+
+		prec := infixPrecedence(l.tok)
+		if prec == precLowest || prec < minPrec {
+			return left
+		}
+		t.nextLexeme()
+
+		switch l.tok {
+		case tokIs:
+			left = t.parseIsNull(left)
+			continue
+		case tokMatches, tokLike:
+			left = t.parseMatch(l, left)
+			continue
+		}
+
+		// all binary operators are left associative
+		rightStart := t.peek()
+		right := t.parseExpr(prec + 1)
+		left = t.newBinaryNode(l, left, right, leftStart, rightStart)
+	}
+}
+
+// newBinaryNode creates the node for the binary operator op, the operands starting at the lexemes leftStart and rightStart.
+func (t *tree) newBinaryNode(op lexeme, left, right node, leftStart, rightStart lexeme) node {
+	switch op.tok {
+	case tokAnd, tokOr:
+		if !isConditionNode(left) {
+			t.errorf(op, "invalid condition before %s", op.val)
+		}
+		if !isConditionNode(right) {
+			t.errorf(op, "invalid condition after %s", op.val)
+		}
 	default:
-		t.errorf("node not a binary expr node")
+		// the other operators compare or compute values
+		if isConditionNode(left) && left.typ() != nodeCall {
+			t.errorf(leftStart, "operand of %s must be a value", op.val)
+		}
+		if isConditionNode(right) && right.typ() != nodeCall {
+			t.errorf(rightStart, "operand of %s must be a value", op.val)
+		}
+	}
+
+	switch op.tok {
+	case tokAnd:
+		return &andNode{nodeType: nodeAnd, left: left, right: right}
+	case tokOr:
+		return &orNode{nodeType: nodeOr, left: left, right: right}
+	case tokIn:
+		return &inNode{nodeType: nodeIn, left: left, right: right}
+	case tokContains:
+		return &containsNode{nodeType: nodeContains, left: left, right: right}
+	case tokPlus, tokMinus, tokStar, tokSlash, tokPercent:
+		return &arithNode{nodeType: nodeArith, left: left, right: right, operator: op.tok}
+	default:
+		return &operationNode{nodeType: nodeOperation, left: left, right: right, operator: op.tok}
+	}
+}
+
+// parseOperand parses an operand of a binary expression: a field, a literal, a list or object literal,
+// a function call, a quantifier, a negation, a negative value or an expression enclosed in parentheses.
+func (t *tree) parseOperand() node {
+	switch l := t.peek(); {
+	case l.tok == tokField:
+		return t.parseChain()
+	case l.tok == tokIdentifier:
+		return t.parseCall()
+	case l.tok > tokLiteralsBegin && l.tok < tokLiteralsEnd:
+		return t.parseLiteral()
+	case l.tok == tokLbracket:
+		return t.parseLiteralSeq()
+	case l.tok == tokLbrace:
+		return t.parseLiteralObject()
+	case l.tok == tokAny, l.tok == tokAll, l.tok == tokNone:
+		return t.parseQuantifier()
+	case l.tok == tokNot:
+		return t.parseNot()
+	case l.tok == tokMinus:
+		return t.parseNegative()
+	case l.tok == tokExists:
+		return t.parseExists()
+	case l.tok == tokLparen:
+		t.nextLexeme()
+		n := t.parseCondition()
+		t.expect(tokRparen, "operator", `")"`)
+
+		return n
+	default:
+		t.unexpected(l, "field", "literal", `"("`)
+	}
+
+	return nil
+}
+
+// expect consumes the next lexeme, which must be a tok.
+func (t *tree) expect(tok token, expected ...string) lexeme {
+	l := t.nextLexeme()
+	if l.tok != tok {
+		t.unexpected(l, expected...)
 	}
+
+	return l
 }
 
 // parseLiteral parses a literal value
@@ -238,89 +766,357 @@ func (t *tree) parseLiteral() node {
 			val:      val,
 		}
 	case l.tok == tokChar, l.tok == tokString:
+		val, err := strconv.Unquote(l.val)
+		if err != nil {
+			t.errorf(l, "bad string syntax %s", l.val)
+		}
+
 		n = &textNode{
 			nodeType: nodeText,
 			text:     l.val,
+			val:      val,
 		}
 	case l.tok == tokNumber:
-		t.backup()
-		n = t.parseNumber()
+		n = t.parseNumber(l, false)
+	case l.tok == tokMinus:
+		n = t.parseNumber(t.expect(tokNumber, "number"), true)
+	case l.tok == tokNull:
+		n = &nullNode{nodeType: nodeNull}
 	}
 
 	return n
 }
 
-// parseNumber parses a number value
-func (t *tree) parseNumber() node {
+// parseNumber parses the number value of the lexeme l, negated if neg is true
+func (t *tree) parseNumber(l lexeme, neg bool) node {
 	var err error
-	l := t.nextLexeme()
 	n := &numberNode{nodeType: nodeNumber}
 
-	if strings.ContainsAny(l.val, "e.") {
+	text := l.val
+	if neg {
+		text = "-" + text
+	}
+
+	if strings.ContainsAny(text, ".eE") {
 		n.isFloat = true
-		n.floatVal, err = strconv.ParseFloat(l.val, 64)
+		n.floatVal, err = strconv.ParseFloat(text, 64)
 		if err != nil {
-			t.errorf("bad number syntax. err=%v", err)
+			t.errorf(l, "bad number syntax %q", text)
 		}
 	} else {
 		n.isInt = true
-		n.intVal, err = strconv.ParseInt(l.val, 10, 64)
-		if err != nil {
-			t.errorf("bad number syntax. err=%v", err)
+		n.intVal, err = strconv.ParseInt(text, 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			n.bigVal, _ = new(big.Int).SetString(text, 10)
+		} else if err != nil {
+			t.errorf(l, "bad number syntax %q", text)
 		}
 	}
 
 	return n
 }
 
-// parseKeyword parses a keyword
-func (t *tree) parseKeyword(left node) node {
-	switch l := t.nextLexeme(); {
-	case l.tok == tokAnd:
-		return &andNode{
-			nodeType: nodeAnd,
-			left:     left,
+// parseNegative parses a unary minus.
+//
+// A minus sign directly followed by a number is a negative number literal.
+func (t *tree) parseNegative() node {
+	t.nextLexeme()
+
+	if l := t.peek(); l.tok == tokNumber {
+		t.nextLexeme()
+		return t.parseNumber(l, true)
+	}
+
+	return &negNode{nodeType: nodeNeg, operand: t.parseOperand()}
+}
+
+// parseAggregate parses an aggregate function like count() or avg(.latency)
+func (t *tree) parseAggregate() node {
+	name := t.nextLexeme()
+
+	n := &aggregateNode{
+		nodeType: nodeAggregate,
+		name:     name.val,
+	}
+
+	t.expect(tokLparen, `"("`)
+	if l := t.peek(); name.val != "count" || l.tok != tokRparen {
+		n.arg = t.parseCondition()
+
+		if isConditionNode(n.arg) && n.arg.typ() != nodeCall {
+			if name.val != "count" {
+				t.errorf(l, "argument of %s must be a value", name.val)
+			}
+			n.cond = true
 		}
-	case l.tok == tokOr:
-		return &orNode{
-			nodeType: nodeOr,
-			left:     left,
+	}
+	end := t.expect(tokRparen, "operator", `")"`)
+
+	n.text = t.lexer.input[name.pos : end.pos+len(end.val)]
+
+	return n
+}
+
+// parseCall parses a function call like lower(.name)
+func (t *tree) parseCall() node {
+	name := t.nextLexeme()
+
+	if t.isAggregate(name.val) {
+		t.errorf(name, "aggregate %s can only be used as a selected field", name.val)
+	}
+
+	fn, ok := t.findFunction(name.val)
+	if !ok {
+		t.errorf(name, "function %q not defined", name.val)
+	}
+
+	n := &callNode{
+		nodeType: nodeCall,
+		name:     name.val,
+		fn:       fn,
+	}
+
+	t.expect(tokLparen, `"("`)
+
+	var argLexemes []lexeme
+
+	end := t.peek()
+	if end.tok == tokRparen {
+		t.nextLexeme()
+	}
+	for end.tok != tokRparen {
+		argLexemes = append(argLexemes, t.peek())
+		n.args = append(n.args, t.parseCondition())
+
+		end = t.nextLexeme()
+		if end.tok != tokComma && end.tok != tokRparen {
+			t.unexpected(end, "operator", `","`, `")"`)
 		}
-	case l.tok == tokIn:
-		return &inNode{
-			nodeType: nodeIn,
-			left:     left,
+	}
+	n.text = t.lexer.input[name.pos : end.pos+len(end.val)]
+
+	typ := fn.Type()
+	switch {
+	case typ.IsVariadic() && len(n.args) < typ.NumIn()-1:
+		t.errorf(name, "wrong number of arguments for %s: want at least %d, got %d", name.val, typ.NumIn()-1, len(n.args))
+	case !typ.IsVariadic() && len(n.args) != typ.NumIn():
+		t.errorf(name, "wrong number of arguments for %s: want %d, got %d", name.val, typ.NumIn(), len(n.args))
+	}
+
+	for i, arg := range n.args {
+		if want := argType(typ, i); !checkArg(arg, want) {
+			t.errorf(argLexemes[i], "wrong type for argument %d of %s: want %s", i+1, name.val, want)
 		}
-	case l.tok == tokContains:
-		return &containsNode{
-			nodeType: nodeContains,
-			left:     left,
+	}
+
+	return n
+}
+
+// parseNot parses the negation of a condition, either written "not" or "!"
+//
+// The negation binds tighter than "and" and "or" but looser than comparisons, so that
+// "not .a == 1 and .b == 2" is parsed as "(not (.a == 1)) and (.b == 2)".
+func (t *tree) parseNot() node {
+	l := t.nextLexeme()
+
+	n := &notNode{
+		nodeType:  nodeNot,
+		condition: t.parseExpr(precCompare),
+	}
+	if !isConditionNode(n.condition) {
+		t.errorf(l, "invalid condition after %s", l.val)
+	}
+
+	return n
+}
+
+// parseIsNull parses the remaining of an IS NULL or IS NOT NULL expression, the "is" being already consumed.
+func (t *tree) parseIsNull(left node) node {
+	n := &isNullNode{
+		nodeType: nodeIsNull,
+		left:     left,
+	}
+
+	if t.peek().tok == tokNot {
+		t.nextLexeme()
+		n.negate = true
+	}
+	t.expect(tokNull, `"null"`)
+
+	return n
+}
+
+// parseMatch parses the pattern of a MATCHES or LIKE expression, the operator being already consumed.
+//
+// The pattern must be a string literal, it's compiled once here so that an invalid pattern is a syntax error.
+func (t *tree) parseMatch(op lexeme, left node) node {
+	l := t.expect(tokString, "string")
+	pattern, err := strconv.Unquote(l.val)
+	if err != nil {
+		t.errorf(l, "bad string syntax %s", l.val)
+	}
+
+	n := &matchNode{
+		nodeType: nodeMatch,
+		left:     left,
+		operator: op.tok,
+		pattern:  pattern,
+	}
+
+	expr := pattern
+	if op.tok == tokLike {
+		expr = globToRegexp(pattern)
+	}
+
+	n.re, err = regexp.Compile(expr)
+	if err != nil {
+		t.errorf(l, "invalid pattern %s: %v", l.val, err)
+	}
+
+	return n
+}
+
+// globToRegexp converts a glob pattern to an anchored regular expression.
+//
+// In a glob, * matches any sequence of characters, ? matches a single character and
+// \ escapes the next character.
+func globToRegexp(glob string) string {
+	var buf bytes.Buffer
+	buf.WriteString(`^(?s:`)
+
+	escaped := false
+	for _, r := range glob {
+		switch {
+		case escaped:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			buf.WriteString(`.*`)
+		case r == '?':
+			buf.WriteString(`.`)
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
 		}
 	}
 
-	return nil
+	if escaped {
+		buf.WriteString(`\\`)
+	}
+	buf.WriteString(`)$`)
+
+	return buf.String()
 }
 
-// parseLiteralSeq parses a sequence of literal values only
-func (t *tree) parseLiteralSeq() node {
-	if t.peek().tok != tokLbracket {
-		return nil
+// parseExists parses an EXISTS expression like exists(.name)
+func (t *tree) parseExists() node {
+	t.nextLexeme()
+	t.expect(tokLparen, `"("`)
+
+	if l := t.peek(); l.tok != tokField {
+		t.unexpected(l, "field")
+	}
+	n := &existsNode{
+		nodeType: nodeExists,
+		chain:    t.parseChain(),
+	}
+
+	t.expect(tokRparen, `")"`)
+
+	return n
+}
+
+// parseQuantifier parses a quantified condition like any(.items, .price > 100)
+func (t *tree) parseQuantifier() node {
+	q := t.nextLexeme()
+	n := &quantifierNode{
+		nodeType:   nodeQuantifier,
+		quantifier: q.tok,
+	}
+
+	t.expect(tokLparen, `"("`)
+
+	if l := t.peek(); l.tok != tokField {
+		t.unexpected(l, "field")
 	}
+	n.seq = t.parseChain()
+
+	t.expect(tokComma, `","`)
+	n.condition = t.parseCondition()
+	t.expect(tokRparen, "operator", `")"`)
+
+	return n
+}
+
+// parseLiteralSeq parses a list like [1, "a", [true], {"b": null}, .name]
+//
+// The elements are values: literals, fields, function calls or arithmetic expressions.
+func (t *tree) parseLiteralSeq() node {
 	t.nextLexeme()
 
 	n := &seqNode{nodeType: nodeSeq}
 
-	for l := t.peek(); l.tok != tokEOF; l = t.peek() {
+	for l := t.peek(); l.tok != tokRbracket; l = t.peek() {
+		if len(n.nodes) > 0 {
+			t.expect(tokComma, `","`, `"]"`)
+		}
+
+		n.nodes = append(n.nodes, t.parseElement("list element"))
+	}
+	t.nextLexeme()
+
+	return n
+}
+
+// parseLiteralObject parses an object like {"lat": 1, "lon": .geo.lon}
+func (t *tree) parseLiteralObject() node {
+	t.nextLexeme()
+
+	n := &objectNode{nodeType: nodeObject}
+	seen := make(map[string]bool)
+
+	for l := t.peek(); l.tok != tokRbrace; l = t.peek() {
+		if len(n.keys) > 0 {
+			t.expect(tokComma, `","`, `"}"`)
+		}
+
+		key := t.nextLexeme()
 		switch {
-		case l.tok == tokComma:
-			t.nextLexeme() // consume
-		case l.tok > tokLiteralsBegin && l.tok < tokLiteralsEnd:
-			n.nodes = append(n.nodes, t.parseLiteral())
-		case l.tok == tokRbracket:
-			t.nextLexeme()
-			return n
+		case key.tok == tokString:
+		case len(n.keys) == 0:
+			t.unexpected(key, "string", `"}"`)
+		default:
+			t.unexpected(key, "string")
+		}
+
+		val, err := strconv.Unquote(key.val)
+		if err != nil {
+			t.errorf(key, "bad string syntax %s", key.val)
+		}
+		if seen[val] {
+			t.errorf(key, "duplicate key %s in object", key.val)
 		}
+		seen[val] = true
+
+		t.expect(tokColon, `":"`)
+
+		n.keys = append(n.keys, val)
+		n.values = append(n.values, t.parseElement("object member"))
 	}
+	t.nextLexeme()
 
-	return nil
+	return n
+}
+
+// parseElement parses a value of a list or an object, which can't be a condition.
+func (t *tree) parseElement(what string) node {
+	l := t.peek()
+
+	n := t.parseExpr(precAdditive)
+	if isConditionNode(n) && n.typ() != nodeCall {
+		t.errorf(l, "%s must be a value", what)
+	}
+
+	return n
 }
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -56,7 +57,7 @@ var parseTests = []parseTest{
 						nodeType: nodeOperation,
 						left:     &chainNode{nodeType: nodeChain, chain: ".name"},
 						right:    &textNode{nodeType: nodeText, text: `"vincent"`},
-						operator: tokEq,
+						operator: tokNeq,
 					},
 				},
 			},
@@ -128,11 +129,320 @@ var parseTests = []parseTest{
 			},
 		}},
 	}},
+	{"with quantifier", `.id where ( any(.items, .price > 100) )`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &quantifierNode{
+					nodeType:   nodeQuantifier,
+					quantifier: tokAny,
+					seq:        &chainNode{nodeType: nodeChain, chain: ".items"},
+					condition: &operationNode{
+						nodeType: nodeOperation,
+						left:     &chainNode{nodeType: nodeChain, chain: ".price"},
+						right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 100},
+						operator: tokGt,
+					},
+				},
+			},
+		}},
+	}},
+	{"with not", `.id where not (.id in [1, 2])`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &notNode{
+					nodeType: nodeNot,
+					condition: &inNode{
+						nodeType: nodeIn,
+						left:     &chainNode{nodeType: nodeChain, chain: ".id"},
+						right: &seqNode{nodeType: nodeSeq, nodes: []node{
+							&numberNode{nodeType: nodeNumber, isInt: true, intVal: 1},
+							&numberNode{nodeType: nodeNumber, isInt: true, intVal: 2},
+						}},
+					},
+				},
+			},
+		}},
+	}},
+	{"precedence", `.id where .a == 1 and .b == 2 or .c == 3 and not .d == 4`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &orNode{
+					nodeType: nodeOr,
+					left: &andNode{
+						nodeType: nodeAnd,
+						left: &operationNode{
+							nodeType: nodeOperation,
+							left:     &chainNode{nodeType: nodeChain, chain: ".a"},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 1},
+							operator: tokEq,
+						},
+						right: &operationNode{
+							nodeType: nodeOperation,
+							left:     &chainNode{nodeType: nodeChain, chain: ".b"},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 2},
+							operator: tokEq,
+						},
+					},
+					right: &andNode{
+						nodeType: nodeAnd,
+						left: &operationNode{
+							nodeType: nodeOperation,
+							left:     &chainNode{nodeType: nodeChain, chain: ".c"},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 3},
+							operator: tokEq,
+						},
+						right: &notNode{
+							nodeType: nodeNot,
+							condition: &operationNode{
+								nodeType: nodeOperation,
+								left:     &chainNode{nodeType: nodeChain, chain: ".d"},
+								right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 4},
+								operator: tokEq,
+							},
+						},
+					},
+				},
+			},
+		}},
+	}},
+	{"grouping", `.id where .a == 1 and (.b == 2 or .c in [3])`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &andNode{
+					nodeType: nodeAnd,
+					left: &operationNode{
+						nodeType: nodeOperation,
+						left:     &chainNode{nodeType: nodeChain, chain: ".a"},
+						right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 1},
+						operator: tokEq,
+					},
+					right: &orNode{
+						nodeType: nodeOr,
+						left: &operationNode{
+							nodeType: nodeOperation,
+							left:     &chainNode{nodeType: nodeChain, chain: ".b"},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 2},
+							operator: tokEq,
+						},
+						right: &inNode{
+							nodeType: nodeIn,
+							left:     &chainNode{nodeType: nodeChain, chain: ".c"},
+							right: &seqNode{nodeType: nodeSeq, nodes: []node{
+								&numberNode{nodeType: nodeNumber, isInt: true, intVal: 3},
+							}},
+						},
+					},
+				},
+			},
+		}},
+	}},
+	{"with null", `.id where .a is not null or exists(.b) and .c == null`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &orNode{
+					nodeType: nodeOr,
+					left: &isNullNode{
+						nodeType: nodeIsNull,
+						left:     &chainNode{nodeType: nodeChain, chain: ".a"},
+						negate:   true,
+					},
+					right: &andNode{
+						nodeType: nodeAnd,
+						left: &existsNode{
+							nodeType: nodeExists,
+							chain:    &chainNode{nodeType: nodeChain, chain: ".b"},
+						},
+						right: &operationNode{
+							nodeType: nodeOperation,
+							left:     &chainNode{nodeType: nodeChain, chain: ".c"},
+							right:    &nullNode{nodeType: nodeNull},
+							operator: tokEq,
+						},
+					},
+				},
+			},
+		}},
+	}},
+	{"arithmetic", `.id where .a + .b * -2 > -.c - 1 % 3`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &operationNode{
+					nodeType: nodeOperation,
+					left: &arithNode{
+						nodeType: nodeArith,
+						left:     &chainNode{nodeType: nodeChain, chain: ".a"},
+						right: &arithNode{
+							nodeType: nodeArith,
+							left:     &chainNode{nodeType: nodeChain, chain: ".b"},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: -2},
+							operator: tokStar,
+						},
+						operator: tokPlus,
+					},
+					right: &arithNode{
+						nodeType: nodeArith,
+						left: &negNode{
+							nodeType: nodeNeg,
+							operand:  &chainNode{nodeType: nodeChain, chain: ".c"},
+						},
+						right: &arithNode{
+							nodeType: nodeArith,
+							left:     &numberNode{nodeType: nodeNumber, isInt: true, intVal: 1},
+							right:    &numberNode{nodeType: nodeNumber, isInt: true, intVal: 3},
+							operator: tokPercent,
+						},
+						operator: tokMinus,
+					},
+					operator: tokGt,
+				},
+			},
+		}},
+	}},
+	{"literals", `.id where .geo == {"lat": 1, "tags": ["a", -2]}`, &tree{
+		root: &seqNode{nodeType: nodeSeq, nodes: []node{
+			&chainNode{nodeType: nodeChain, chain: ".id"},
+			&whereNode{
+				nodeType: nodeWhere,
+				condition: &operationNode{
+					nodeType: nodeOperation,
+					left:     &chainNode{nodeType: nodeChain, chain: ".geo"},
+					right: &objectNode{
+						nodeType: nodeObject,
+						keys:     []string{"lat", "tags"},
+						values: []node{
+							&numberNode{nodeType: nodeNumber, isInt: true, intVal: 1},
+							&seqNode{nodeType: nodeSeq, nodes: []node{
+								&textNode{nodeType: nodeText, text: `"a"`, val: "a"},
+								&numberNode{nodeType: nodeNumber, isInt: true, intVal: -2},
+							}},
+						},
+					},
+					operator: tokEq,
+				},
+			},
+		}},
+	}},
+}
+
+type parseChainTest struct {
+	input string
+	chain string
+	steps []step
+}
+
+var parseChainTests = []parseChainTest{
+	{".", ".", nil},
+	{".data.id", ".data.id", []step{
+		{kind: stepField, name: "data"},
+		{kind: stepField, name: "id"},
+	}},
+	{".items[0].sku", ".items[0].sku", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepIndex, index: 0, hasLo: true},
+		{kind: stepField, name: "sku"},
+	}},
+	{".items[-1]", ".items[-1]", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepIndex, index: -1, hasLo: true},
+	}},
+	{".items[1:3]", ".items[1:3]", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepSlice, index: 1, hasLo: true, hi: 3, hasHi: true},
+	}},
+	{".items[:-1]", ".items[:-1]", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepSlice, hi: -1, hasHi: true},
+	}},
+	{".items[2:]", ".items[2:]", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepSlice, index: 2, hasLo: true},
+	}},
+	{".items[*].tags[0]", ".items[*].tags[0]", []step{
+		{kind: stepField, name: "items"},
+		{kind: stepWildcard},
+		{kind: stepField, name: "tags"},
+		{kind: stepIndex, index: 0, hasLo: true},
+	}},
+	{".device.os?", ".device.os?", []step{
+		{kind: stepField, name: "device"},
+		{kind: stepField, name: "os"},
+	}},
+}
+
+func TestParseChain(t *testing.T) {
+	for _, test := range parseChainTests {
+		tr := parse(t, &parseTest{input: test.input})
+
+		equals(t, 1, len(tr.root.nodes))
+		chain := tr.root.nodes[0].(*chainNode)
+		equals(t, test.chain, chain.chain)
+		equals(t, test.steps, chain.steps)
+		equals(t, strings.HasSuffix(test.chain, "?"), chain.optional)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		". where (.a == 1",
+		". where (.a == 1))",
+		". where .a == 1 .b == 2",
+		". where .a == 1 .b",
+		". where .a == 1 where .b == 2",
+		". where .a == and .b == 2",
+		". where .a ==",
+		". where any(.items .a == 1)",
+		". where any(.items, .a == 1",
+		". where .a is 1",
+		". where .a is not",
+		". where exists(1)",
+		". where exists(.a",
+		".a??",
+		". where .a + > 1",
+		". where .a * 2",
+		".items[-]",
+		". where .a in [-]",
+		". where .a in [1,]",
+		". where .a in [1 2]",
+		". where .a == {1: 2}",
+		`. where .a == {"a" 1}`,
+		`. where .a == {"a": 1,}`,
+		`. where .a == {"a": 1, "a": 2}`,
+		`. where .a == {"a": (.b == 1)}`,
+		`. where .a == {"a": 1`,
+	} {
+		err := newTree(newLexer(input)).parse()
+		assert(t, err != nil, "expected an error for %q", input)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	equals(t, `^(?s:.*\.example\.com)$`, globToRegexp("*.example.com"))
+	equals(t, `^(?s:a.c)$`, globToRegexp("a?c"))
+	equals(t, `^(?s:a\*c\?)$`, globToRegexp(`a\*c\?`))
+	equals(t, `^(?s:\[a\]\\)$`, globToRegexp(`[a]\`))
+}
+
+func TestParseChainErrors(t *testing.T) {
+	for _, input := range []string{".items[", ".items[a]", ".items[1.5]", ".items[1", ".items[*", ".items[1:2:3]"} {
+		err := newTree(newLexer(input)).parse()
+		assert(t, err != nil, "expected an error for %q", input)
+	}
 }
 
 func parse(t testing.TB, test *parseTest) *tree {
 	l := newLexer(test.input)
-	l.lex()
 	tr := newTree(l)
 
 	err := tr.parse()
@@ -142,7 +452,7 @@ func parse(t testing.TB, test *parseTest) *tree {
 }
 
 func TestParse(t *testing.T) {
-	for _, test := range parseTests[len(parseTests)-1:] {
+	for _, test := range parseTests {
 		tr := parse(t, &test)
 		equals(t, printIndentRoot(test.tree.root), printIndentRoot(tr.root))
 	}
@@ -0,0 +1,441 @@
+package haddoque
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// InvalidJSONError is returned by ExecBytes when the data is not valid JSON.
+//
+// Values which are skipped because the query doesn't use them are only checked for their structure,
+// so some malformed documents may not be detected.
+type InvalidJSONError struct {
+	// Offset is the byte offset of the problem in the data.
+	Offset int
+	// Msg is the description of the problem.
+	Msg string
+}
+
+func (e *InvalidJSONError) Error() string {
+	return fmt.Sprintf("invalid JSON at offset %d: %s", e.Offset, e.Msg)
+}
+
+// Is makes errors.Is(err, ErrInvalidObject) true for an *InvalidJSONError.
+func (e *InvalidJSONError) Is(target error) bool {
+	return target == ErrInvalidObject
+}
+
+// pathTrie is the set of the paths of an object used by a query.
+//
+// Only object fields are followed: anything below an array selector is needed entirely.
+type pathTrie struct {
+	all      bool // the whole value is needed
+	children map[string]*pathTrie
+}
+
+// add adds the path made of the steps to the trie.
+func (p *pathTrie) add(steps []step) {
+	n := p
+	for _, s := range steps {
+		if n.all {
+			return
+		}
+
+		if s.kind != stepField {
+			break
+		}
+
+		if n.children == nil {
+			n.children = make(map[string]*pathTrie)
+		}
+
+		child, ok := n.children[s.name]
+		if !ok {
+			child = &pathTrie{}
+			n.children[s.name] = child
+		}
+		n = child
+	}
+
+	n.all = true
+	n.children = nil
+}
+
+// queryPaths returns the paths of the object used by the query.
+func queryPaths(root *seqNode) *pathTrie {
+	p := &pathTrie{}
+	addNodePaths(p, root)
+
+	return p
+}
+
+func addNodePaths(p *pathTrie, n node) {
+	switch v := n.(type) {
+	case *chainNode:
+		p.add(v.steps)
+	case *seqNode:
+		for _, el := range v.nodes {
+			addNodePaths(p, el)
+		}
+	case *objectNode:
+		for _, el := range v.values {
+			addNodePaths(p, el)
+		}
+	case *whereNode:
+		addNodePaths(p, v.condition)
+	case *andNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *orNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *inNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *containsNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *operationNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *arithNode:
+		addNodePaths(p, v.left)
+		addNodePaths(p, v.right)
+	case *negNode:
+		addNodePaths(p, v.operand)
+	case *notNode:
+		addNodePaths(p, v.condition)
+	case *isNullNode:
+		addNodePaths(p, v.left)
+	case *existsNode:
+		addNodePaths(p, v.chain)
+	case *matchNode:
+		addNodePaths(p, v.left)
+	case *callNode:
+		for _, el := range v.args {
+			addNodePaths(p, el)
+		}
+	case *aggregateNode:
+		if v.arg != nil {
+			addNodePaths(p, v.arg)
+		}
+	case *groupNode:
+		for _, el := range v.keys {
+			addNodePaths(p, el)
+		}
+	case *orderNode:
+		for _, el := range v.keys {
+			addNodePaths(p, el)
+		}
+	case *distinctNode:
+		for _, el := range v.keys {
+			addNodePaths(p, el)
+		}
+	case *computedNode:
+		addNodePaths(p, v.expr)
+	case *aliasNode:
+		addNodePaths(p, v.expr)
+	case *quantifierNode:
+		// the condition applies to the elements, which are needed entirely
+		addNodePaths(p, v.seq)
+	}
+}
+
+// rawScanner extracts the values of a JSON document needed by a query, skipping the rest without decoding it.
+type rawScanner struct {
+	data []byte
+	pos  int
+}
+
+// extractJSON decodes the parts of the JSON document data found in the paths.
+//
+// Numbers are decoded as json.Number, to keep their exact value.
+func extractJSON(data []byte, paths *pathTrie) (interface{}, error) {
+	s := &rawScanner{data: data}
+
+	s.skipWhitespace()
+	if s.pos < len(s.data) && s.data[s.pos] != '{' {
+		// anything else than an object is needed entirely to tell why it's not queryable
+		paths = &pathTrie{all: true}
+	}
+
+	res, err := s.extract(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	s.skipWhitespace()
+	if s.pos < len(s.data) {
+		return nil, s.errorf("unexpected data after top-level value")
+	}
+
+	return res, nil
+}
+
+func (s *rawScanner) errorf(format string, args ...interface{}) error {
+	return &InvalidJSONError{Offset: s.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// extract returns the value at the current position, keeping only the fields found in the paths.
+//
+// A value which isn't an object while the paths need some of its fields is returned as null:
+// none of these fields can exist anyway.
+func (s *rawScanner) extract(p *pathTrie) (interface{}, error) {
+	s.skipWhitespace()
+
+	if p.all {
+		return s.decode()
+	}
+
+	if s.pos >= len(s.data) || s.data[s.pos] != '{' {
+		return nil, s.skip()
+	}
+	s.pos++
+
+	res := make(map[string]interface{})
+
+	s.skipWhitespace()
+	if s.pos < len(s.data) && s.data[s.pos] == '}' {
+		s.pos++
+		return res, nil
+	}
+
+	for {
+		s.skipWhitespace()
+
+		key, err := s.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if child, ok := p.children[key]; ok {
+			if res[key], err = s.extract(child); err != nil {
+				return nil, err
+			}
+		} else if err := s.skip(); err != nil {
+			return nil, err
+		}
+
+		s.skipWhitespace()
+		switch {
+		case s.pos >= len(s.data):
+			return nil, s.errorf("unexpected end of data")
+		case s.data[s.pos] == ',':
+			s.pos++
+		case s.data[s.pos] == '}':
+			s.pos++
+			return res, nil
+		default:
+			return nil, s.errorf("expected ',' or '}' after object value")
+		}
+	}
+}
+
+// key reads an object key and the colon following it.
+func (s *rawScanner) key() (string, error) {
+	start := s.pos
+	if err := s.skipString(); err != nil {
+		return "", err
+	}
+
+	var key string
+	raw := s.data[start:s.pos]
+	if bytes.IndexByte(raw, '\\') < 0 {
+		key = string(raw[1 : len(raw)-1])
+	} else if err := json.Unmarshal(raw, &key); err != nil {
+		return "", &InvalidJSONError{Offset: start, Msg: "invalid object key"}
+	}
+
+	s.skipWhitespace()
+	if s.pos >= len(s.data) || s.data[s.pos] != ':' {
+		return "", s.errorf("expected ':' after object key")
+	}
+	s.pos++
+
+	return key, nil
+}
+
+// decode fully decodes the value at the current position.
+func (s *rawScanner) decode() (interface{}, error) {
+	start := s.pos
+	if err := s.skip(); err != nil {
+		return nil, err
+	}
+
+	// scalars are decoded directly, they are by far the most common values in conditions
+	raw := s.data[start:s.pos]
+	switch c := raw[0]; {
+	case c == 't':
+		return true, nil
+	case c == 'f':
+		return false, nil
+	case c == 'n':
+		return nil, nil
+	case c == '"' && bytes.IndexByte(raw, '\\') < 0:
+		return string(raw[1 : len(raw)-1]), nil
+	case (c == '-' || ('0' <= c && c <= '9')) && isValidNumber(raw):
+		return json.Number(raw), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var res interface{}
+	if err := dec.Decode(&res); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			return nil, &InvalidJSONError{Offset: start + int(serr.Offset), Msg: serr.Error()}
+		}
+		return nil, &InvalidJSONError{Offset: start, Msg: err.Error()}
+	}
+	if n := int(dec.InputOffset()); n != len(raw) {
+		return nil, &InvalidJSONError{Offset: start + n, Msg: "invalid value"}
+	}
+
+	return res, nil
+}
+
+// isValidNumber reports whether the number has the JSON syntax.
+func isValidNumber(b []byte) bool {
+	i := 0
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+
+	digits := func() int {
+		n := 0
+		for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+			n++
+		}
+		return n
+	}
+
+	switch {
+	case i < len(b) && b[i] == '0':
+		i++
+	case digits() == 0:
+		return false
+	}
+
+	if i < len(b) && b[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false
+		}
+	}
+
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+
+	return i == len(b)
+}
+
+// skip moves past the value at the current position.
+func (s *rawScanner) skip() error {
+	s.skipWhitespace()
+	if s.pos >= len(s.data) {
+		return s.errorf("unexpected end of data")
+	}
+
+	switch c := s.data[s.pos]; {
+	case c == '"':
+		return s.skipString()
+	case c == '{' || c == '[':
+		return s.skipComposite()
+	case c == '-' || ('0' <= c && c <= '9'):
+		for s.pos < len(s.data) && bytes.IndexByte([]byte("+-0123456789.eE"), s.data[s.pos]) >= 0 {
+			s.pos++
+		}
+		return nil
+	case c == 't':
+		return s.skipLiteral("true")
+	case c == 'f':
+		return s.skipLiteral("false")
+	case c == 'n':
+		return s.skipLiteral("null")
+	default:
+		return s.errorf("invalid character %q looking for beginning of value", c)
+	}
+}
+
+func (s *rawScanner) skipLiteral(lit string) error {
+	if !bytes.HasPrefix(s.data[s.pos:], []byte(lit)) {
+		return s.errorf("invalid literal, expected %s", lit)
+	}
+	s.pos += len(lit)
+
+	return nil
+}
+
+// skipString moves past the string at the current position.
+func (s *rawScanner) skipString() error {
+	if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+		return s.errorf("expected string")
+	}
+
+	for i := s.pos + 1; i < len(s.data); i++ {
+		switch s.data[i] {
+		case '\\':
+			i++
+		case '"':
+			s.pos = i + 1
+			return nil
+		}
+	}
+
+	return s.errorf("unterminated string")
+}
+
+// skipComposite moves past the object or array at the current position, checking only
+// that the brackets are balanced.
+func (s *rawScanner) skipComposite() error {
+	var stack []byte
+
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; c {
+		case '"':
+			if err := s.skipString(); err != nil {
+				return err
+			}
+			continue
+		case '{', '[':
+			stack = append(stack, c)
+		case '}', ']':
+			open := byte('{')
+			if c == ']' {
+				open = '['
+			}
+
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return s.errorf("unexpected %q", c)
+			}
+			stack = stack[:len(stack)-1]
+
+			if len(stack) == 0 {
+				s.pos++
+				return nil
+			}
+		}
+		s.pos++
+	}
+
+	return s.errorf("unexpected end of data")
+}
+
+func (s *rawScanner) skipWhitespace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
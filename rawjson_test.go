@@ -0,0 +1,93 @@
+package haddoque
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestQueryPaths(t *testing.T) {
+	tr := newTree(newLexer(`.id, .device.os where .items[0].sku == "a" and any(.tags, . == "b") and lower(.device.name) == "x" and .device.os.version > 1`))
+	ok(t, tr.parse())
+
+	p := queryPaths(tr.root)
+	equals(t, false, p.all)
+	equals(t, 4, len(p.children))
+	equals(t, true, p.children["id"].all)
+	equals(t, true, p.children["items"].all)
+	equals(t, true, p.children["tags"].all)
+	equals(t, false, p.children["device"].all)
+	equals(t, true, p.children["device"].children["os"].all)
+	equals(t, true, p.children["device"].children["name"].all)
+
+	tr = newTree(newLexer(`.id, . where .a == 1`))
+	ok(t, tr.parse())
+	equals(t, &pathTrie{all: true}, queryPaths(tr.root))
+}
+
+var extractTests = []struct {
+	query string
+	data  string
+	exp   interface{}
+}{
+	{`.a`, `{"a": 1, "b": {"c": [1, 2, {"d": "}"}]}}`, map[string]interface{}{"a": json.Number("1")}},
+	{`.a.b`, `{"a": {"b": "x\"y", "c": [true]}, "d": null}`, map[string]interface{}{
+		"a": map[string]interface{}{"b": `x"y`},
+	}},
+	{`.a.b`, `{"a": [1, 2], "z": 1}`, map[string]interface{}{"a": nil}},
+	{`.a`, `{"ab": 1, "a\u0062": 2}`, map[string]interface{}{}},
+	{`.ab`, `{"a\u0062": 1}`, map[string]interface{}{"ab": json.Number("1")}},
+	{`.`, `{"a": 1.5e3}`, map[string]interface{}{"a": json.Number("1.5e3")}},
+	{`.a`, ` [1, {"a": 2}] `, []interface{}{json.Number("1"), map[string]interface{}{"a": json.Number("2")}}},
+	{`.a`, `{}`, map[string]interface{}{}},
+}
+
+func TestExtractJSON(t *testing.T) {
+	for _, test := range extractTests {
+		tr := newTree(newLexer(test.query))
+		ok(t, tr.parse())
+
+		res, err := extractJSON([]byte(test.data), queryPaths(tr.root))
+		ok(t, err)
+		equals(t, test.exp, res)
+	}
+}
+
+func TestExtractJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`{`,
+		`{"a": 1`,
+		`{"a" 1}`,
+		`{"a": 1,}`,
+		`{"a": 1} x`,
+		`{"a": tru}`,
+		`{"a": {"b": 1]}`,
+		`{"a": "b}`,
+		`{a: 1}`,
+		`{"a": [1, 2}`,
+		`{"b": x}`,
+		`{"a": {"b": }}`,
+		`{"a": 01}`,
+		`{"a": 1.}`,
+		`{"a": -}`,
+		`{"a": 1e}`,
+	} {
+		tr := newTree(newLexer(`.a`))
+		ok(t, tr.parse())
+
+		_, err := extractJSON([]byte(data), queryPaths(tr.root))
+		var jerr *InvalidJSONError
+		assert(t, errors.As(err, &jerr), "expected an InvalidJSONError for %q, got %v", data, err)
+		assert(t, errors.Is(err, ErrInvalidObject), "expected the error to be ErrInvalidObject for %q", data)
+	}
+}
+
+func TestIsValidNumber(t *testing.T) {
+	for _, n := range []string{"0", "-0", "1", "-12", "1.5", "0.25", "1e3", "1E+3", "-1.5e-3"} {
+		assert(t, isValidNumber([]byte(n)), "expected %q to be valid", n)
+	}
+	for _, n := range []string{"", "-", "01", "1.", ".5", "1e", "1e+", "+1", "1-2", "1.2.3"} {
+		assert(t, !isValidNumber([]byte(n)), "expected %q to be invalid", n)
+	}
+}
@@ -0,0 +1,399 @@
+package haddoque
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// this follows the rules of encoding/json, so that querying a Go value gives the same
+// result as querying its JSON encoding.
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+)
+
+// structField is a field of a struct as seen by encoding/json.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFieldsCache caches the fields of the struct types, it's a map[reflect.Type][]structField.
+var structFieldsCache sync.Map
+
+// cachedStructFields returns the fields of the struct type, computing them once per type.
+func cachedStructFields(typ reflect.Type) []structField {
+	if f, ok := structFieldsCache.Load(typ); ok {
+		return f.([]structField)
+	}
+
+	f, _ := structFieldsCache.LoadOrStore(typ, structFields(typ, nil))
+	return f.([]structField)
+}
+
+// structFields returns the fields of the struct type which are encoded by encoding/json.
+//
+// The fields of embedded structs are promoted, unless a field of the same name exists at a shallower depth.
+func structFields(typ reflect.Type, index []int) []structField {
+	var fields, embedded []structField
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if sf.Anonymous && sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr {
+			// the fields of an unexported embedded pointer can't be reached
+			continue
+		}
+
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, structFields(ft, fieldIndex)...)
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		seen[f.name] = true
+	}
+	for _, f := range embedded {
+		if !seen[f.name] {
+			fields = append(fields, f)
+			seen[f.name] = true
+		}
+	}
+
+	return fields
+}
+
+// fieldByIndex returns the field of the struct at index, or false if it's behind a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+// startDetectingCyclesAfter is the depth after which the cycles are detected, like encoding/json,
+// so that the common case of a shallow value costs nothing.
+const startDetectingCyclesAfter = 1000
+
+// cycleDetector detects the pointers, maps and slices which contain themselves,
+// and which can't be represented in JSON.
+type cycleDetector struct {
+	depth int
+	seen  map[cycleKey]struct{}
+}
+
+// cycleKey identifies a pointer, map or slice; a slice is identified by its length too,
+// since a slice of its first elements has the same pointer.
+type cycleKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+func newCycleKey(v reflect.Value) cycleKey {
+	k := cycleKey{typ: v.Type(), ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+
+	return k
+}
+
+// enter records that the value v is being converted, it returns false if it already was.
+func (c *cycleDetector) enter(v reflect.Value) bool {
+	c.depth++
+	if c.depth <= startDetectingCyclesAfter {
+		return true
+	}
+
+	if c.seen == nil {
+		c.seen = make(map[cycleKey]struct{})
+	}
+
+	k := newCycleKey(v)
+	if _, ok := c.seen[k]; ok {
+		return false
+	}
+	c.seen[k] = struct{}{}
+
+	return true
+}
+
+// leave records that the value v is converted.
+func (c *cycleDetector) leave(v reflect.Value) {
+	if c.depth > startDetectingCyclesAfter {
+		delete(c.seen, newCycleKey(v))
+	}
+	c.depth--
+}
+
+// newReflectObjNode fills on with the value v of any Go type, with the same structure as its JSON encoding.
+//
+// It returns nil if the value contains a cycle.
+func newReflectObjNode(on *objNode, v reflect.Value, c *cycleDetector) *objNode {
+	if !v.IsValid() {
+		on.kind = objNull
+		return on
+	}
+
+	typ := v.Type()
+
+	switch {
+	case typ == jsonNumberType:
+		on.kind = objValue
+		on.value = v.Interface()
+		return on
+	case typ.Implements(jsonMarshalerType) && !isNilValue(v):
+		return newMarshalerObjNode(on, v, c)
+	case typ.Implements(textMarshalerType) && !isNilValue(v):
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			on.kind = objNull
+			return on
+		}
+
+		on.kind = objValue
+		on.value = string(text)
+		return on
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			on.kind = objNull
+			return on
+		}
+
+		return newReflectObjNode(on, v.Elem(), c)
+	case reflect.Ptr:
+		if v.IsNil() {
+			on.kind = objNull
+			return on
+		}
+
+		if !c.enter(v) {
+			return nil
+		}
+		defer c.leave(v)
+
+		return newReflectObjNode(on, v.Elem(), c)
+	case reflect.Struct:
+		for _, f := range cachedStructFields(typ) {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) || !isEncodable(fv.Type()) {
+				continue
+			}
+
+			field := newReflectObjNode(&objNode{name: f.name}, fv, c)
+			if field == nil {
+				return nil
+			}
+			on.fields = append(on.fields, field)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			on.kind = objNull
+			return on
+		}
+
+		if !c.enter(v) {
+			return nil
+		}
+		defer c.leave(v)
+
+		iter := v.MapRange()
+		for iter.Next() {
+			field := newReflectObjNode(&objNode{name: mapKey(iter.Key())}, iter.Value(), c)
+			if field == nil {
+				return nil
+			}
+			on.fields = append(on.fields, field)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			on.kind = objNull
+			return on
+		}
+
+		if typ.Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			on.kind = objValue
+			on.value = base64.StdEncoding.EncodeToString(v.Bytes())
+			return on
+		}
+
+		if v.Kind() == reflect.Slice {
+			if !c.enter(v) {
+				return nil
+			}
+			defer c.leave(v)
+		}
+
+		on.kind = objArray
+		on.fields = make([]*objNode, v.Len())
+		for i := range on.fields {
+			if on.fields[i] = newReflectObjNode(&objNode{name: strconv.Itoa(i)}, v.Index(i), c); on.fields[i] == nil {
+				return nil
+			}
+		}
+	default:
+		on.kind = objValue
+		on.value = basicValue(v)
+		if on.value == nil {
+			on.kind = objNull
+		}
+	}
+
+	return on
+}
+
+// newMarshalerObjNode fills on with the JSON encoding of a json.Marshaler.
+func newMarshalerObjNode(on *objNode, v reflect.Value, c *cycleDetector) *objNode {
+	on.kind = objNull
+
+	data, err := v.Interface().(json.Marshaler).MarshalJSON()
+	if err != nil {
+		return on
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return on
+	}
+
+	return newObjNode1(on, on.name, obj, c)
+}
+
+// basicValue returns the value of a boolean, string or number as one of the predeclared types,
+// so that a named type like "type Status string" is handled like a string.
+//
+// It returns nil for the values which can't be represented in JSON.
+func basicValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return normalizeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	default:
+		return nil
+	}
+}
+
+// mapKey returns the name of the field for a map key, like encoding/json.
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if text, err := tm.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+
+	return ""
+}
+
+// isEncodable reports whether values of the type can be represented in JSON.
+func isEncodable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return false
+	default:
+		return true
+	}
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// isEmptyValue reports whether the value is empty as defined by the omitempty option of encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
@@ -0,0 +1,134 @@
+package haddoque
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testStatus string
+
+type testBase struct {
+	ID      int64     `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type testMeta struct {
+	Source string `json:"source"`
+	ID     string `json:"id"`
+}
+
+type testEvent struct {
+	testBase
+	*testMeta                // ignored like encoding/json does, being an unexported pointer
+	Name      string         `json:"name"`
+	Status    testStatus     `json:"status"`
+	Tags      []string       `json:"tags"`
+	Labels    map[string]int `json:"labels,omitempty"`
+	Counts    map[int]uint8  `json:"counts"`
+	Payload   []byte         `json:"payload"`
+	Parent    *testEvent     `json:"parent"`
+	Extra     interface{}    `json:"extra"`
+	Skipped   string         `json:"-"`
+	Untagged  bool
+	Callback  func()            `json:"callback"`
+	Headers   map[string]string `json:",omitempty"`
+	secret    string
+}
+
+func TestStructFields(t *testing.T) {
+	var names []string
+	for _, f := range structFields(reflect.TypeOf(testEvent{}), nil) {
+		names = append(names, f.name)
+	}
+
+	equals(t, []string{
+		"name", "status", "tags", "labels", "counts", "payload", "parent", "extra",
+		"Untagged", "callback", "Headers", "id", "created",
+	}, names)
+
+	fields := cachedStructFields(reflect.TypeOf(testEvent{}))
+	equals(t, fields, cachedStructFields(reflect.TypeOf(testEvent{})))
+	equals(t, []int{0, 0}, fields[11].index)
+	equals(t, true, fields[3].omitEmpty)
+}
+
+func TestNewObjNodeStruct(t *testing.T) {
+	ev := &testEvent{
+		testBase: testBase{ID: 12, Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Name:     "signup",
+		Status:   "ok",
+		Tags:     []string{"a", "b"},
+		Counts:   map[int]uint8{1: 2},
+		Payload:  []byte("hi"),
+		Parent:   &testEvent{Name: "root"},
+		Extra:    map[string]interface{}{"n": json.Number("9007199254740993")},
+		secret:   "s",
+	}
+
+	on := newObjNode(ev)
+	assert(t, on != nil, "expected an objNode")
+
+	equals(t, map[string]interface{}{
+		"id":       int64(12),
+		"created":  "2020-01-02T03:04:05Z",
+		"name":     "signup",
+		"status":   "ok",
+		"tags":     []interface{}{"a", "b"},
+		"counts":   map[string]interface{}{"1": int64(2)},
+		"payload":  "aGk=",
+		"Untagged": false,
+		"extra":    map[string]interface{}{"n": json.Number("9007199254740993")},
+		"parent": map[string]interface{}{
+			"id":       int64(0),
+			"created":  "0001-01-01T00:00:00Z",
+			"name":     "root",
+			"status":   "",
+			"tags":     nil,
+			"counts":   nil,
+			"payload":  nil,
+			"parent":   nil,
+			"extra":    nil,
+			"Untagged": false,
+		},
+	}, on.data())
+}
+
+func TestNewObjNodeInvalid(t *testing.T) {
+	for _, obj := range []interface{}{nil, 1, "foo", true, (*testEvent)(nil), func() {}} {
+		assert(t, newObjNode(obj) == nil, "expected no objNode for %#v", obj)
+	}
+}
+
+type testNode struct {
+	Name string    `json:"name"`
+	Next *testNode `json:"next,omitempty"`
+}
+
+func TestNewObjNodeCycles(t *testing.T) {
+	loop := &testNode{Name: "a"}
+	loop.Next = &testNode{Name: "b", Next: loop}
+
+	m := map[string]interface{}{"id": 1}
+	m["self"] = m
+
+	s := []interface{}{1, nil}
+	s[1] = s
+
+	for _, obj := range []interface{}{loop, m, s, map[string]interface{}{"list": []interface{}{m}}} {
+		_, err := Exec(`.`, obj)
+		equals(t, ErrInvalidObject, err)
+	}
+
+	// a value referenced more than once, or a long chain, isn't a cycle
+	shared := &testNode{Name: "shared"}
+	on := newObjNode(map[string]interface{}{"a": shared, "b": []*testNode{shared, shared}})
+	assert(t, on != nil, "expected an objNode for a shared value")
+
+	var list *testNode
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		list = &testNode{Name: "n", Next: list}
+	}
+	assert(t, newObjNode(list) != nil, "expected an objNode for a long list")
+}
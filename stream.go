@@ -0,0 +1,568 @@
+package haddoque
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RecordError is the error of a single record of a stream, which doesn't stop the stream.
+type RecordError struct {
+	// Line is the line of the first byte of the record in the stream, starting at 1.
+	Line int
+	// Err is the underlying error: an *InvalidJSONError, a *MissingFieldsError or ErrInvalidObject.
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// MaxRecordSize is the maximum size in bytes of a record read from a stream, a larger one is invalid.
+const MaxRecordSize = 16 << 20
+
+// MaxRecordErrors is the maximum number of errors kept in RecordErrors, the next ones are only counted.
+const MaxRecordErrors = 100
+
+// RecordErrors is returned by Filter when some records failed.
+type RecordErrors struct {
+	// Errors are the first MaxRecordErrors errors, in the order of the records.
+	Errors []*RecordError
+	// Dropped is the number of errors after the first MaxRecordErrors, which are not kept.
+	Dropped int
+}
+
+func (e RecordErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	if e.Dropped > 0 {
+		msgs = append(msgs, fmt.Sprintf("and %d more", e.Dropped))
+	}
+
+	return fmt.Sprintf("%d invalid records: %s", len(e.Errors)+e.Dropped, strings.Join(msgs, "; "))
+}
+
+// add adds err, or counts it once MaxRecordErrors errors are kept.
+func (e *RecordErrors) add(err *RecordError) {
+	if len(e.Errors) < MaxRecordErrors {
+		e.Errors = append(e.Errors, err)
+	} else {
+		e.Dropped++
+	}
+}
+
+// Record is a single JSON value read from a stream.
+type Record struct {
+	// Line is the line of the first byte of the record, starting at 1.
+	Line int
+	// Raw is the JSON text of the record. It is only valid until the next call to Next.
+	Raw []byte
+	// Matched is true if the record satisfies the query.
+	Matched bool
+	// Result is the result of the query for a matching record.
+	Result interface{}
+	// Err is a *RecordError if the record is invalid or the query failed on it.
+	Err error
+}
+
+// Iterator reads the JSON values of a stream one by one and executes a query on each of them.
+//
+// The stream can be newline-delimited JSON, concatenated JSON values, or JSON arrays:
+// the elements of an array at the top level are read as separate records.
+//
+// If the first object of the stream fits on its line, the stream is newline-delimited: an object
+// which isn't complete at the end of its line is invalid, and the reading resumes on the next line.
+// Otherwise an object can span lines, and an incomplete one only ends where an object or an array
+// starts a line after a complete value; after "key": or a comma, such a line is read as part of it.
+//
+// Only one record is held in memory at a time, of at most MaxRecordSize bytes, so the DISTINCT, ORDER BY, LIMIT and OFFSET clauses
+// of the query are ignored, see Deduplicator.Iterate and Collector.
+//
+// Iteration follows the style of bufio.Scanner:
+//
+//	it := haddoque.NewIterator(r, q)
+//	for it.Next() {
+//		rec := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	r       *bufio.Reader
+	q       *Query
+	exec    func(data []byte) (interface{}, bool, error)
+	line    int
+	newline bool // the last byte read was a newline
+	cut     bool // the last value was cut short where the next one starts
+	lines   bool // the stream is newline-delimited, so a value can't span lines
+	checked bool // lines is set, from the first object of the stream
+	inArray bool
+	buf     bytes.Buffer
+	rec     Record
+	err     error
+}
+
+// NewIterator returns an Iterator executing q on the JSON values read from r.
+func NewIterator(r io.Reader, q *Query) *Iterator {
+	return newIterator(r, q, q.execBytes)
+}
+
+// newIterator returns an Iterator executing exec on the JSON values read from r, instead of q.
+func newIterator(r io.Reader, q *Query, exec func(data []byte) (interface{}, bool, error)) *Iterator {
+	return &Iterator{
+		r:    bufio.NewReader(r),
+		q:    q,
+		exec: exec,
+		line: 1,
+	}
+}
+
+// Next advances to the next record, which is then available through Record.
+//
+// An invalid record doesn't stop the iteration, its Err field is set instead.
+// Next returns false at the end of the stream or on a read error, available through Err.
+func (it *Iterator) Next() bool {
+	for {
+		c, err := it.skipWhitespace()
+		if err != nil {
+			if err == io.EOF && it.inArray {
+				it.inArray = false
+				it.setError(it.line, &InvalidJSONError{Msg: "unexpected end of data in array"})
+				return true
+			}
+
+			it.fail(err)
+			return false
+		}
+
+		switch {
+		case c == '[' && !it.inArray:
+			it.inArray = true
+			continue
+		case c == ']' && it.inArray:
+			it.inArray = false
+			continue
+		case c == ',' && it.inArray:
+			continue
+		}
+
+		line := it.line
+		if err := it.readValue(c); err != nil {
+			var jerr *InvalidJSONError
+			if !errors.As(err, &jerr) {
+				it.fail(err)
+				return false
+			}
+
+			// resynchronize on the next line, in case the stream is newline-delimited
+			if !it.newline && !it.cut {
+				it.skipLine()
+			}
+
+			it.setError(line, err)
+			return true
+		}
+
+		raw := it.buf.Bytes()
+		if !it.checked && !it.inArray && c == '{' {
+			it.checked = true
+			it.lines = bytes.IndexByte(raw, '\n') < 0
+		}
+
+		res, matched, err := it.exec(raw)
+		if err != nil {
+			// an invalid number or literal is most likely a line of garbage,
+			// whose next words would be read as more invalid values
+			var jerr *InvalidJSONError
+			if errors.As(err, &jerr) && strings.IndexByte(`{["`, c) < 0 && !it.newline {
+				it.skipLine()
+			}
+
+			it.setError(line, err)
+			return true
+		}
+
+		it.rec = Record{
+			Line:    line,
+			Raw:     raw,
+			Matched: matched,
+			Result:  res,
+		}
+
+		return true
+	}
+}
+
+// Record returns the current record.
+func (it *Iterator) Record() Record {
+	return it.rec
+}
+
+// Err returns the read error which stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) fail(err error) {
+	if err != io.EOF {
+		it.err = err
+	}
+	it.rec = Record{}
+}
+
+// setError sets the current record, starting at line, to an invalid record.
+func (it *Iterator) setError(line int, err error) {
+	it.rec = Record{
+		Line: line,
+		Raw:  it.buf.Bytes(),
+		Err:  &RecordError{Line: line, Err: err},
+	}
+}
+
+func (it *Iterator) readByte() (byte, error) {
+	c, err := it.r.ReadByte()
+	if err == nil && c == '\n' {
+		it.line++
+	}
+	it.newline = err == nil && c == '\n'
+
+	return c, err
+}
+
+// skipLine discards the rest of the current line.
+func (it *Iterator) skipLine() {
+	for {
+		c, err := it.readByte()
+		if err != nil || c == '\n' {
+			return
+		}
+	}
+}
+
+// writeByte adds c to the current record, unless it's already MaxRecordSize bytes long.
+func (it *Iterator) writeByte(c byte) error {
+	if it.buf.Len() >= MaxRecordSize {
+		return &InvalidJSONError{Offset: it.buf.Len(), Msg: "record too large"}
+	}
+
+	it.buf.WriteByte(c)
+	return nil
+}
+
+func (it *Iterator) unreadByte(c byte) {
+	it.r.UnreadByte()
+	if c == '\n' {
+		it.line--
+	}
+	it.newline = false
+}
+
+func (it *Iterator) skipWhitespace() (byte, error) {
+	for {
+		c, err := it.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return c, nil
+		}
+	}
+}
+
+// readValue reads the JSON value starting with c into the buffer, by finding where it ends.
+//
+// The value itself is checked when the query is executed. A newline in a string, or an object or array
+// starting a line where no value is expected, ends the value with an error, so that the next line
+// of a newline-delimited stream is read normally.
+func (it *Iterator) readValue(c byte) error {
+	it.buf.Reset()
+	it.buf.WriteByte(c)
+	it.cut = false
+
+	switch c {
+	case '{', '[':
+		return it.readComposite(c)
+	case '"':
+		return it.readString()
+	}
+
+	// a number or a literal, or garbage, ends at the next whitespace or delimiter
+	for {
+		c, err := it.readByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if strings.IndexByte(" \t\r\n,[]{}\"", c) >= 0 {
+			it.unreadByte(c)
+			return nil
+		}
+
+		if err := it.writeByte(c); err != nil {
+			return err
+		}
+	}
+}
+
+func (it *Iterator) readComposite(open byte) error {
+	stack := []byte{open}
+
+	// last is the last byte read outside of strings and whitespace, eol is true if a newline follows it
+	last, eol := open, false
+
+	for len(stack) > 0 {
+		c, err := it.readByte()
+		if err == io.EOF {
+			return &InvalidJSONError{Offset: it.buf.Len(), Msg: "unexpected end of data"}
+		}
+		if err != nil {
+			return err
+		}
+
+		if (c == '{' || c == '[') && eol && strings.IndexByte("{[,:", last) < 0 {
+			// this line can't continue the value, it's most likely the next record of
+			// a newline-delimited stream: the value is truncated
+			it.unreadByte(c)
+			it.cut = true
+			it.buf.Truncate(len(bytes.TrimRight(it.buf.Bytes(), " \t\r\n")))
+
+			return &InvalidJSONError{Offset: it.buf.Len(), Msg: "unexpected end of line"}
+		}
+
+		if err := it.writeByte(c); err != nil {
+			return err
+		}
+
+		switch c {
+		case ' ', '\t', '\r':
+			continue
+		case '\n':
+			if it.lines && !it.inArray {
+				it.buf.Truncate(len(bytes.TrimRight(it.buf.Bytes(), " \t\r\n")))
+				return &InvalidJSONError{Offset: it.buf.Len(), Msg: "unexpected end of line"}
+			}
+
+			eol = true
+			continue
+		case '"':
+			if err := it.readString(); err != nil {
+				return err
+			}
+		case '{', '[':
+			stack = append(stack, c)
+		case '}', ']':
+			want := byte('{')
+			if c == ']' {
+				want = '['
+			}
+
+			if stack[len(stack)-1] != want {
+				return &InvalidJSONError{Offset: it.buf.Len() - 1, Msg: fmt.Sprintf("unexpected %q", c)}
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		last, eol = c, false
+	}
+
+	return nil
+}
+
+// readString reads the rest of a string, its opening quote being already read.
+func (it *Iterator) readString() error {
+	for {
+		c, err := it.readByte()
+		if err == io.EOF {
+			return &InvalidJSONError{Offset: it.buf.Len(), Msg: "unterminated string"}
+		}
+		if err != nil {
+			return err
+		}
+
+		switch c {
+		case '\n':
+			return &InvalidJSONError{Offset: it.buf.Len(), Msg: "newline in string"}
+		case '\\':
+			if err := it.writeByte(c); err != nil {
+				return err
+			}
+			if c, err = it.readByte(); err != nil {
+				continue
+			}
+		case '"':
+			return it.writeByte(c)
+		}
+
+		if err := it.writeByte(c); err != nil {
+			return err
+		}
+	}
+}
+
+// FilterOption is an option of Filter, NewAggregator and NewCollector.
+type FilterOption func(o *filterOptions)
+
+type filterOptions struct {
+	capacity int
+	window   time.Duration
+	onError  func(err *RecordError) error
+}
+
+// WithDistinctBounds bounds the keys remembered for a DISTINCT clause, like NewDeduplicator does.
+func WithDistinctBounds(capacity int, window time.Duration) FilterOption {
+	return func(o *filterOptions) {
+		o.capacity = capacity
+		o.window = window
+	}
+}
+
+// WithRecordErrorHandler makes Filter, Aggregator.AddStream or Collector.AddStream report each invalid record
+// to fn as soon as it's read, instead of returning them as RecordErrors once the input is exhausted.
+// The reading stops if fn returns an error, which is returned.
+func WithRecordErrorHandler(fn func(err *RecordError) error) FilterOption {
+	return func(o *filterOptions) {
+		o.onError = fn
+	}
+}
+
+// Filter executes the query on each JSON value read from r, and writes the results
+// of the matching ones to w as newline-delimited JSON.
+//
+// The input is read with an Iterator, see its documentation for the supported formats.
+// Invalid records don't stop the filtering, they're returned as RecordErrors once the input is exhausted,
+// the ones after the first MaxRecordErrors being only counted, or reported as they're read with
+// WithRecordErrorHandler. Any other error is a read or write error, which stops the filtering.
+//
+// With a DISTINCT clause, only the first of the values with the same keys is written. All the keys are kept,
+// unless bounded with WithDistinctBounds.
+//
+// With ORDER BY, LIMIT or OFFSET clauses, the results are gathered with a Collector and written
+// once the input is exhausted, or once the limit is reached without ORDER BY clause.
+//
+// ErrAggregateQuery is returned for a query with aggregates, see Aggregator.
+func Filter(r io.Reader, w io.Writer, q *Query, opts ...FilterOption) error {
+	if q.aggregate {
+		return ErrAggregateQuery
+	}
+
+	var o filterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	if q.IsCollection() {
+		return filterCollection(r, enc, q, opts)
+	}
+
+	sr := streamReader{
+		exec: q.execBytes,
+		record: func(rec Record) error {
+			if !rec.Matched {
+				return nil
+			}
+			return enc.Encode(rec.Result)
+		},
+		onError: o.onError,
+	}
+	if q.distinct != nil {
+		d, _ := NewDeduplicator(q, o.capacity, o.window)
+		sr.exec = d.ExecBytes
+	}
+
+	return sr.read(r, q)
+}
+
+// filterCollection executes a query with ORDER BY, LIMIT or OFFSET clauses with a Collector created with the options
+// of Filter, and writes the results once the stream is exhausted.
+func filterCollection(r io.Reader, enc *json.Encoder, q *Query, opts []FilterOption) error {
+	c, err := NewCollector(q, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = c.AddStream(r)
+
+	var errs RecordErrors
+	if err != nil && !errors.As(err, &errs) {
+		return err
+	}
+
+	for _, res := range c.Results() {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// streamReader reads the JSON values of a stream with an Iterator, executing exec on each of them.
+type streamReader struct {
+	exec func(data []byte) (interface{}, bool, error)
+	// done stops the reading early once it returns true, if set.
+	done func() bool
+	// record is called with each valid record, if set.
+	record func(rec Record) error
+	// onError is called with each invalid record if set, they're returned as RecordErrors otherwise,
+	// keeping at most MaxRecordErrors of them.
+	onError func(err *RecordError) error
+}
+
+// read reads the stream r, executing the query q with exec.
+//
+// Invalid records don't stop the reading. Any other error is a read error, or an error
+// returned by record or onError, which stops the reading.
+func (s streamReader) read(r io.Reader, q *Query) error {
+	it := newIterator(r, q, s.exec)
+
+	var errs RecordErrors
+	for (s.done == nil || !s.done()) && it.Next() {
+		rec := it.Record()
+
+		var err error
+		switch {
+		case rec.Err != nil && s.onError != nil:
+			err = s.onError(rec.Err.(*RecordError))
+		case rec.Err != nil:
+			errs.add(rec.Err.(*RecordError))
+		case s.record != nil:
+			err = s.record(rec)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package haddoque_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/vrischmann/haddoque"
+)
+
+func TestFilterNDJSON(t *testing.T) {
+	input := `{"id": 1, "type": "click"}
+{"id": 2, "type": "view"}
+{"id": 3, "type": "click", "name": "a\"b"}
+`
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id where .type == "click"`))
+	ok(t, err)
+	equals(t, "{\"id\":1}\n{\"id\":3}\n", buf.String())
+}
+
+func TestFilterFormats(t *testing.T) {
+	q := haddoque.MustCompile(`.id where .id > 1`)
+
+	for _, input := range []string{
+		// concatenated values
+		`{"id": 1}{"id": 2}  {"id": 3}`,
+		// pretty-printed values
+		"{\n  \"id\": 1\n}\n{\n  \"id\": 2\n}\n{\n  \"id\": 3\n}\n",
+		// top-level array
+		`[{"id": 1}, {"id": 2}, {"id": 3}]`,
+		"[\n  {\"id\": 1},\n  {\"id\": 2}\n]\n[{\"id\": 3}]",
+	} {
+		var buf bytes.Buffer
+		ok(t, haddoque.Filter(strings.NewReader(input), &buf, q))
+		equals(t, "{\"id\":2}\n{\"id\":3}\n", buf.String())
+	}
+}
+
+func TestFilterRecordErrors(t *testing.T) {
+	input := `{"id": 1}
+{"id": 2, "name": "unterminated}
+{"id": tru}
+{"name": "no id"}
+"not an object"
+{"id": 3} }
+{"id": 4, "nested": {"a": 1]}
+{"id": 5}
+`
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id`))
+	equals(t, "{\"id\":1}\n{\"id\":3}\n{\"id\":5}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+
+	var lines []int
+	for _, rerr := range errs.Errors {
+		lines = append(lines, rerr.Line)
+	}
+	equals(t, []int{2, 3, 4, 5, 6, 7}, lines)
+
+	equals(t, true, errors.Is(errs.Errors[1], haddoque.ErrInvalidObject))
+	equals(t, true, errors.Is(errs.Errors[2], haddoque.ErrNonExistingFields))
+	equals(t, haddoque.ErrInvalidObject, errs.Errors[3].Err)
+	equals(t, 0, errs.Dropped)
+}
+
+func TestFilterGarbageLine(t *testing.T) {
+	input := "{\"id\": 1}\nnot json at all\n{\"id\": 2} tru\nfalse\n{\"id\": 3}\n"
+
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id`))
+	equals(t, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+
+	var lines []int
+	for _, rerr := range errs.Errors {
+		lines = append(lines, rerr.Line)
+	}
+	equals(t, []int{2, 3, 4}, lines)
+}
+
+func TestFilterTruncatedRecord(t *testing.T) {
+	// the first object spans lines, so the next ones can too
+	input := `{"id": 1,
+  "t": "c"}
+{"id": 2, "t": "c"
+{"id": 3, "t": "c"}
+{"id": 4, "nested": [1,
+  {"t": "c"}]}
+{"id": 5, "nested": [
+{"t": "c"}
+{"id": 6, "t": "c"}
+`
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id where .t == "c" or exists(.nested)`))
+	equals(t, "{\"id\":1}\n{\"id\":3}\n{\"id\":4}\n{\"id\":6}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+
+	var lines []int
+	for _, rerr := range errs.Errors {
+		lines = append(lines, rerr.Line)
+	}
+	equals(t, []int{3, 7}, lines)
+}
+
+func TestFilterNDJSONTruncatedRecord(t *testing.T) {
+	input := `{"id": 1, "t": "c"}
+{"id": 2, "broken":
+{"id": 3, "t": "c"}
+{"id": 4, "nested": [
+{"id": 5, "t": "c"}
+[{"id": 6, "t": "c"},
+{"id": 7, "t": "c"}]
+`
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id where .t == "c"`))
+	equals(t, "{\"id\":1}\n{\"id\":3}\n{\"id\":5}\n{\"id\":6}\n{\"id\":7}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+
+	var lines []int
+	for _, rerr := range errs.Errors {
+		lines = append(lines, rerr.Line)
+	}
+	equals(t, []int{2, 4}, lines)
+}
+
+func TestFilterRecordTooLarge(t *testing.T) {
+	input := `{"id": 1, "s": "` + strings.Repeat("a", haddoque.MaxRecordSize) + "\"}\n" + `{"id": 2}`
+
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id`))
+	equals(t, "{\"id\":2}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+	equals(t, 1, len(errs.Errors))
+	equals(t, 1, errs.Errors[0].Line)
+	assert(t, strings.HasSuffix(err.Error(), "record too large"), "unexpected error %q", err)
+}
+
+func TestFilterRecordErrorsDropped(t *testing.T) {
+	input := strings.Repeat("{\"id\": ]\n", haddoque.MaxRecordErrors+5) + `{"id": 1}`
+
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id`))
+	equals(t, "{\"id\":1}\n", buf.String())
+
+	var errs haddoque.RecordErrors
+	assert(t, errors.As(err, &errs), "expected RecordErrors, got %v", err)
+
+	equals(t, haddoque.MaxRecordErrors, len(errs.Errors))
+	equals(t, haddoque.MaxRecordErrors, errs.Errors[haddoque.MaxRecordErrors-1].Line)
+	equals(t, 5, errs.Dropped)
+	assert(t, strings.HasSuffix(err.Error(), "; and 5 more"), "unexpected error %q", err)
+}
+
+func TestFilterRecordErrorHandler(t *testing.T) {
+	input := `{"id": 1}
+{"id": ]
+{"name": "no id"}
+{"id": 2}
+{"id": ]
+{"id": 3}
+`
+	for _, query := range []string{`.id`, `.id order by .id desc`} {
+		var lines []int
+		handler := haddoque.WithRecordErrorHandler(func(err *haddoque.RecordError) error {
+			lines = append(lines, err.Line)
+			return nil
+		})
+
+		var buf bytes.Buffer
+		ok(t, haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(query), handler))
+		equals(t, []int{2, 3, 5}, lines)
+		equals(t, 3, strings.Count(buf.String(), "\n"))
+	}
+
+	// the filtering stops at the error returned by the handler
+	errTooMany := errors.New("too many errors")
+	n := 0
+	handler := haddoque.WithRecordErrorHandler(func(err *haddoque.RecordError) error {
+		if n++; n == 2 {
+			return errTooMany
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	err := haddoque.Filter(strings.NewReader(input), &buf, haddoque.MustCompile(`.id`), handler)
+	equals(t, errTooMany, err)
+	equals(t, "{\"id\":1}\n", buf.String())
+}
+
+func TestIterator(t *testing.T) {
+	input := "[\n{\"id\": 1},\n{\"id\": 2},\n{\"id\":\n  tru}\n]\n{\"id\": 4}"
+
+	it := haddoque.NewIterator(strings.NewReader(input), haddoque.MustCompile(`.id where .id != 2`))
+
+	var recs []haddoque.Record
+	for it.Next() {
+		rec := it.Record()
+		rec.Raw = append([]byte(nil), rec.Raw...)
+		recs = append(recs, rec)
+	}
+	ok(t, it.Err())
+
+	equals(t, 4, len(recs))
+
+	equals(t, 2, recs[0].Line)
+	equals(t, `{"id": 1}`, string(recs[0].Raw))
+	equals(t, true, recs[0].Matched)
+	equals(t, map[string]interface{}{"id": json.Number("1")}, recs[0].Result)
+
+	equals(t, 3, recs[1].Line)
+	equals(t, false, recs[1].Matched)
+	equals(t, nil, recs[1].Result)
+
+	equals(t, 4, recs[2].Line)
+	var rerr *haddoque.RecordError
+	assert(t, errors.As(recs[2].Err, &rerr), "expected a RecordError, got %v", recs[2].Err)
+	equals(t, 4, rerr.Line)
+
+	equals(t, 7, recs[3].Line)
+	equals(t, true, recs[3].Matched)
+}
+
+func TestIteratorUnterminatedArray(t *testing.T) {
+	it := haddoque.NewIterator(strings.NewReader(`[{"id": 1}, `), haddoque.MustCompile(`.id`))
+
+	equals(t, true, it.Next())
+	ok(t, it.Record().Err)
+	equals(t, true, it.Next())
+	assert(t, it.Record().Err != nil, "expected an error for the unterminated array")
+	equals(t, false, it.Next())
+	ok(t, it.Err())
+}
+
+func TestIteratorReadError(t *testing.T) {
+	readErr := errors.New("read error")
+
+	it := haddoque.NewIterator(iotest.ErrReader(readErr), haddoque.MustCompile(`.id`))
+	equals(t, false, it.Next())
+	equals(t, readErr, it.Err())
+
+	err := haddoque.Filter(iotest.ErrReader(readErr), &bytes.Buffer{}, haddoque.MustCompile(`.id`))
+	equals(t, readErr, err)
+}
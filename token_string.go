@@ -4,9 +4,9 @@ package haddoque
 
 import "fmt"
 
-const _token_name = "tokErrortokEOFtokWhitespacetokFieldtokIdentifiertokLiteralsBegintokBooltokChartokStringtokNumbertokLiteralsEndtokLparentokRparentokLbrackettokRbrackettokCommatokKeywordsBegintokWheretokAndtokOrtokIntokContainstokKeywordsEndtokOperatorsBegintokLttokLtetokGttokGtetokEqtokNeqtokNottokOperatorsEnd"
+const _token_name = "tokErrortokEOFtokWhitespacetokFieldtokIdentifiertokLiteralsBegintokBooltokChartokStringtokNumbertokNulltokLiteralsEndtokLparentokRparentokLbrackettokRbrackettokLbracetokRbracetokCommatokColontokStartokQuestiontokPlustokMinustokSlashtokPercenttokKeywordsBegintokWheretokAndtokOrtokIntokContainstokAnytokAlltokNonetokIstokExiststokMatchestokLiketokGrouptokBytokOrdertokAsctokDesctokLimittokOffsettokDistincttokOntokSelecttokAstokKeywordsEndtokOperatorsBegintokLttokLtetokGttokGtetokEqtokNeqtokNottokOperatorsEnd"
 
-var _token_index = [...]uint16{0, 8, 14, 27, 35, 48, 64, 71, 78, 87, 96, 110, 119, 128, 139, 150, 158, 174, 182, 188, 193, 198, 209, 223, 240, 245, 251, 256, 262, 267, 273, 279, 294}
+var _token_index = [...]uint16{0, 8, 14, 27, 35, 48, 64, 71, 78, 87, 96, 103, 117, 126, 135, 146, 157, 166, 175, 183, 191, 198, 209, 216, 224, 232, 242, 258, 266, 272, 277, 282, 293, 299, 305, 312, 317, 326, 336, 343, 351, 356, 364, 370, 377, 385, 394, 405, 410, 419, 424, 438, 455, 460, 466, 471, 477, 482, 488, 494, 509}
 
 func (i token) String() string {
 	if i < 0 || i+1 >= token(len(_token_index)) {